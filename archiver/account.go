@@ -0,0 +1,50 @@
+package archiver
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// getAndSaveAccount saves a dated snapshot of the account details, such as the subscription level and
+// number of seats
+func getAndSaveAccount(config LambdaConfig) error {
+	account, err := getObject[KnowBe4Account](config, config.objectOptions(entityAccount, accountURLPath))
+	if err != nil {
+		return errors.New("error getting account from KnowBe4 ..." + err.Error())
+	}
+
+	currentTime := time.Now().Format("2006-01-02")
+	account.SnapshotDate = currentTime
+
+	if err := saveList(config, entityAccount, []KnowBe4Account{account},
+		config.objectKey(entityAccount, accountFilenamePrefix+currentTime+".jsonl", 0)); err != nil {
+		return errors.New("error saving account ..." + err.Error())
+	}
+
+	log.Printf("saved account %s", account.Name)
+	return nil
+}
+
+// getAndSaveAccountRiskScores saves a dated snapshot of the organization's full risk score history
+func getAndSaveAccountRiskScores(config LambdaConfig) error {
+	history, err := getRiskScoreHistory(config,
+		config.objectOptions(entityAccountRiskScores, accountRiskScoreHistoryURLPath))
+	if err != nil {
+		return errors.New("error getting account risk score history from KnowBe4 ..." + err.Error())
+	}
+
+	list := make([]RiskScoreRow, len(history))
+	for i, h := range history {
+		list[i] = RiskScoreRow{EntityType: riskScoreEntityTypeAccount, Date: h.Date, RiskScore: h.RiskScore}
+	}
+
+	currentTime := time.Now().Format("2006-01-02")
+	if err := saveList(config, entityAccountRiskScores, list,
+		config.objectKey(entityAccountRiskScores, accountRiskScoresPrefix+currentTime+".jsonl", 0)); err != nil {
+		return errors.New("error saving account risk scores ..." + err.Error())
+	}
+
+	log.Printf("saved %d account risk scores", len(history))
+	return nil
+}
@@ -0,0 +1,63 @@
+package archiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getAndSaveAccount(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+accountURLPath, getTestHandler(exampleAccount))
+	mux.HandleFunc("/"+accountRiskScoreHistoryURLPath, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("true", r.URL.Query().Get("full"))
+		_, _ = w.Write([]byte(exampleRiskScoreHistory))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: server.URL, sink: sink}
+
+	assert.NoError(getAndSaveAccount(config))
+	assert.NoError(getAndSaveAccountRiskScores(config))
+
+	currentTime := time.Now().Format("2006-01-02")
+	r, err := sink.Get(accountFilenamePrefix + currentTime + ".jsonl")
+	assert.NoError(err)
+	defer r.Close()
+
+	var account KnowBe4Account
+	assert.NoError(json.NewDecoder(r).Decode(&account))
+	assert.Equal("KB4-Demo", account.Name)
+	assert.Equal([]string{"kb4-demo.com"}, account.Domains)
+	assert.Equal("grace.o@kb4-demo.com", account.Admins[0].Email)
+	assert.Equal(25, account.NumberOfSeats)
+	assert.Equal(currentTime, account.SnapshotDate)
+
+	rows := readRiskScoreRows(t, sink, accountRiskScoresPrefix+currentTime+".jsonl")
+	assert.Equal([]RiskScoreRow{
+		{EntityType: riskScoreEntityTypeAccount, Date: "2020-11-06", RiskScore: 32.7108},
+		{EntityType: riskScoreEntityTypeAccount, Date: "2020-11-07", RiskScore: 32.6839},
+	}, rows)
+}
+
+func Test_getAndSaveAccount_failure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: server.URL, sink: sink, RetryMaxAttempts: 1}
+
+	require.Error(t, getAndSaveAccount(config))
+
+	objects, err := sink.List("account/")
+	require.NoError(t, err)
+	require.Empty(t, objects)
+}
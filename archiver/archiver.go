@@ -0,0 +1,1317 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	countPerPage     = 500
+	maxErrorsAllowed = 5
+
+	// unchangedMaxAge is the oldest an unchanged object can be and still not be uploaded again. It is
+	// half of the bucket's 15 day expiration.
+	unchangedMaxAge = 7 * 24 * time.Hour
+)
+
+// version is the archiver's version, set at build time with -ldflags "-X <this package>.version=..."
+var version = "dev"
+
+const (
+	// https://developer.knowbe4.com/reporting/#tag/Account/paths/~1v1~1account/get
+	accountURLPath = "v1/account"
+
+	// https://developer.knowbe4.com/reporting/#tag/Account/paths/~1v1~1account~1risk_score_history/get
+	accountRiskScoreHistoryURLPath = "v1/account/risk_score_history"
+
+	// https://developer.knowbe4.com/reporting/#tag/Phishing/paths/~1v1~1phishing~1campaigns/get
+	campaignsURLPath = "v1/phishing/campaigns"
+
+	// https://developer.knowbe4.com/reporting/#tag/Groups/paths/~1v1~1groups/get
+	groupsURLPath = "v1/groups"
+
+	// https://developer.knowbe4.com/reporting/#tag/Groups/paths/~1v1~1groups~1{group_id}~1members/get
+	groupMembersURLPath = "v1/groups/%v/members"
+
+	// https://developer.knowbe4.com/reporting/#tag/Groups/paths/~1v1~1groups~1{group_id}~1risk_score_history/get
+	groupRiskScoreHistoryURLPath = "v1/groups/%v/risk_score_history"
+
+	// https://developer.knowbe4.com/reporting/#tag/Phishing/paths/~1v1~1phishing~1security_tests~1{pst_id}~1recipients/get
+	recipientsURLPath = "v1/phishing/security_tests/%v/recipients"
+
+	// https://developer.knowbe4.com/reporting/#tag/Phishing/paths/~1v1~1phishing~1security_tests/get
+	securityTestURLPath = "v1/phishing/security_tests"
+
+	// https://developer.knowbe4.com/reporting/#tag/Training/paths/~1v1~1training~1campaigns/get
+	trainingCampaignsURLPath = "v1/training/campaigns"
+
+	// https://developer.knowbe4.com/reporting/#tag/Training/paths/~1v1~1training~1enrollments/get
+	trainingEnrollmentsURLPath = "v1/training/enrollments"
+
+	// https://developer.knowbe4.com/reporting/#tag/Training/paths/~1v1~1training~1policies/get
+	trainingPoliciesURLPath = "v1/training/policies"
+
+	// https://developer.knowbe4.com/reporting/#tag/Training/paths/~1v1~1training~1store_purchases/get
+	trainingStorePurchasesURLPath = "v1/training/store_purchases"
+
+	// https://developer.knowbe4.com/reporting/#tag/Account/paths/~1v1~1account/get
+	usersURLPath = "v1/users"
+
+	// https://developer.knowbe4.com/reporting/#tag/Users/paths/~1v1~1users~1{user_id}~1risk_score_history/get
+	userRiskScoreHistoryURLPath = "v1/users/%v/risk_score_history"
+)
+
+const (
+	accountFilenamePrefix   = "account/knowbe4_account_"
+	accountRiskScoresPrefix = "account/risk_scores/knowbe4_account_risk_scores_"
+
+	campaignsFilename        = "campaigns/all_campaigns/knowbe4_campaigns.jsonl"
+	groupsFilename           = "groups/knowbe4_groups.jsonl"
+	groupMembersPrefix       = "groups/members/knowbe4_group_members_"
+	phishingTestsFilename    = "campaigns/pst/knowbe4_security_tests.jsonl"
+	recipientsFilenamePrefix = "recipients/knowbe4_recipients_"
+	usersFilenamePrefix      = "users/knowbe4_users_"
+
+	groupRiskScoresPrefix = "risk_scores/groups/knowbe4_group_risk_scores_"
+	userRiskScoresPrefix  = "risk_scores/users/knowbe4_user_risk_scores_"
+
+	trainingCampaignsFilename   = "training/campaigns/knowbe4_training_campaigns.jsonl"
+	trainingEnrollmentsFilename = "training/enrollments/knowbe4_training_enrollments.jsonl"
+
+	trainingPoliciesFilenamePrefix       = "training/policies/knowbe4_training_policies_"
+	trainingStorePurchasesFilenamePrefix = "training/store_purchases/knowbe4_training_store_purchases_"
+)
+
+// Entities that can be selected in LambdaConfig.Entities
+const (
+	entityAccount           = "account"
+	entityAccountRiskScores = "account_risk_scores"
+
+	entityCampaigns     = "campaigns"
+	entityGroups        = "groups"
+	entityGroupMembers  = "group_members"
+	entityUsers         = "users"
+	entitySecurityTests = "security_tests"
+	entityRecipients    = "recipients"
+
+	entityGroupRiskScores = "group_risk_scores"
+	entityUserRiskScores  = "user_risk_scores"
+
+	entityTrainingCampaigns   = "training_campaigns"
+	entityTrainingEnrollments = "training_enrollments"
+
+	entityTrainingPolicies       = "training_policies"
+	entityTrainingStorePurchases = "training_store_purchases"
+)
+
+// allEntities lists every entity in the order they are archived
+var allEntities = []string{
+	entityAccount,
+	entityAccountRiskScores,
+	entityCampaigns,
+	entityGroups,
+	entityGroupMembers,
+	entityUsers,
+	entityTrainingCampaigns,
+	entityTrainingEnrollments,
+	entityTrainingPolicies,
+	entityTrainingStorePurchases,
+	entitySecurityTests,
+	entityRecipients,
+	entityGroupRiskScores,
+	entityUserRiskScores,
+}
+
+// optInEntities are only archived when they are selected, as they take one API request per user or group
+var optInEntities = map[string]bool{
+	entityGroupRiskScores: true,
+	entityUserRiskScores:  true,
+}
+
+const (
+	EnvAPIBaseURL   = "API_BASE_URL"
+	EnvAPIAuthToken = "API_AUTH_TOKEN"
+	EnvAWSS3Bucket  = "AWS_S3_BUCKET"
+
+	EnvDestination          = "ARCHIVE_DESTINATION"
+	EnvAPIRequestsPerSecond = "API_REQUESTS_PER_SECOND"
+	EnvAPIDailyRequestLimit = "API_DAILY_REQUEST_LIMIT"
+	EnvRecipientConcurrency = "RECIPIENT_CONCURRENCY"
+	EnvRawResponses         = "ARCHIVE_RAW_RESPONSES"
+	EnvSchemaDriftMode      = "SCHEMA_DRIFT_MODE"
+	EnvCompression          = "ARCHIVE_COMPRESSION"
+	EnvFormat               = "ARCHIVE_FORMAT"
+	EnvPartitionedKeys      = "ARCHIVE_PARTITIONED_KEYS"
+	EnvKeyTemplate          = "ARCHIVE_KEY_TEMPLATE"
+	EnvForceUpload          = "ARCHIVE_FORCE_UPLOAD"
+	EnvServerSideEncryption = "ARCHIVE_SSE"
+	EnvSSEKMSKeyID          = "ARCHIVE_SSE_KMS_KEY_ID"
+	EnvObjectTags           = "ARCHIVE_OBJECT_TAGS"
+	EnvRedactedDestination  = "ARCHIVE_REDACTED_DESTINATION"
+	EnvEntityOptions        = "ARCHIVE_ENTITY_OPTIONS"
+	EnvPseudonymKey         = "ARCHIVE_PSEUDONYM_KEY"
+
+	EnvReconciliationFailPercent = "RECONCILIATION_FAIL_PERCENT"
+)
+
+type LambdaConfig struct {
+	APIBaseURL    string `json:"APIBaseURL"`
+	APIAuthToken  string `json:"APIAuthToken"`
+	AWSS3Bucket   string `json:"AWSS3Bucket"`
+	AWSS3Filename string `json:"AWSS3FileName"`
+	MaxFileCount  int    `json:"MaxFileCount"`
+
+	// Destination is where archived data is written, as an s3://bucket/prefix or file:///directory URL.
+	// It defaults to the root of AWSS3Bucket.
+	Destination string `json:"Destination"`
+
+	// RetryMaxAttempts is the most times a single API request is tried (default 5)
+	RetryMaxAttempts int `json:"RetryMaxAttempts"`
+
+	// RetryMaxElapsedSeconds caps the total time spent retrying a single API request (default 120)
+	RetryMaxElapsedSeconds int `json:"RetryMaxElapsedSeconds"`
+
+	// APIRequestsPerSecond is the average rate of API requests allowed (default 4)
+	APIRequestsPerSecond float64 `json:"APIRequestsPerSecond"`
+
+	// APIDailyRequestLimit is the number of API requests all runs may use in a UTC day, counted in the
+	// saved state. Zero means no limit.
+	APIDailyRequestLimit int `json:"APIDailyRequestLimit"`
+
+	// RecipientConcurrency is the number of security tests whose recipients are fetched at once (default 5)
+	RecipientConcurrency int `json:"RecipientConcurrency"`
+
+	// FullRun archives recipients for every security test, ignoring what the saved state says has
+	// already been archived
+	FullRun bool `json:"FullRun"`
+
+	// Entities limits the run to these entities, e.g. ["users", "groups"]. All entities are archived if
+	// it is empty.
+	Entities []string `json:"Entities"`
+
+	// EntityOptions holds settings for individual entities, keyed by entity name. Runs without them in
+	// the event read them from ARCHIVE_ENTITY_OPTIONS.
+	EntityOptions map[string]EntityOptions `json:"EntityOptions"`
+
+	// RawResponses also saves the exact body of every API response, under raw/<entity>/<run ID>/, so
+	// fields that aren't in the typed output can be recovered later
+	RawResponses bool `json:"RawResponses"`
+
+	// SchemaDriftMode sets what happens when API responses don't match the structs in types.go: "off"
+	// (the default), "warn" to log each difference, or "strict" to also fail the entities affected. Unless
+	// it is off, a report of the differences is saved for each run.
+	SchemaDriftMode string `json:"SchemaDriftMode"`
+
+	// Compression is the codec archived JSON Lines and CSV files are compressed with: "none" (the
+	// default), "gzip" or "zstd". The codec's extension is added to each key, e.g. ".jsonl.gz".
+	Compression string `json:"Compression"`
+
+	// Format is the output format of entities that don't set one in EntityOptions: "jsonl" (the
+	// default), "parquet" or "csv"
+	Format string `json:"Format"`
+
+	// PartitionedKeys saves every entity under Hive-style keys like
+	// "entity=users/dt=2026-10-16/part-0000.jsonl", so each day's files are kept rather than overwritten
+	PartitionedKeys bool `json:"PartitionedKeys"`
+
+	// KeyTemplate lays out the keys of archived files, and implies PartitionedKeys. It may use {entity},
+	// {date}, {year}, {month}, {day} and {part}, and must include {entity} and {part}. The extension of
+	// the format is added to it.
+	KeyTemplate string `json:"KeyTemplate"`
+
+	// ForceUpload uploads every file, even when the object already saved at its key has the same content
+	ForceUpload bool `json:"ForceUpload"`
+
+	// ServerSideEncryption is how S3 encrypts archived objects: "AES256", "aws:kms", or empty for the
+	// bucket's default
+	ServerSideEncryption string `json:"ServerSideEncryption"`
+
+	// SSEKMSKeyID is the KMS key used with "aws:kms" encryption. The AWS managed key is used if it is empty.
+	SSEKMSKeyID string `json:"SSEKMSKeyID"`
+
+	// ObjectTags are added to every object saved to S3, e.g. {"classification": "pii"}
+	ObjectTags map[string]string `json:"ObjectTags"`
+
+	// RedactedDestination is where copies of archived files are saved with the redaction policies in
+	// EntityOptions applied, as an s3:// or file:// URL like Destination. If it is empty, the policies apply
+	// to the files saved to Destination instead.
+	RedactedDestination string `json:"RedactedDestination"`
+
+	// PseudonymKey is the HMAC key for fields the redaction policies pseudonymize. Runs with the same key
+	// give the same person the same pseudonym.
+	PseudonymKey string `json:"PseudonymKey"`
+
+	// ReconciliationFailPercent fails recipients when the counters of more than this percent of the
+	// security tests saved don't match their recipients. Zero means mismatches are only reported.
+	ReconciliationFailPercent float64 `json:"ReconciliationFailPercent"`
+
+	// runID identifies this run in the keys of raw responses
+	runID string
+
+	limiter        *rateLimiter
+	drift          *driftReport
+	manifest       *runManifest
+	reconciliation *reconciliation
+	integrity      *integrityCheck
+	codec          codec
+	sink           Sink
+	stateStore     StateStore
+
+	// redactedSink is the sink for RedactedDestination, or nil if it isn't set
+	redactedSink Sink
+
+	// ctx is the context for the run, or the context of one batch job within it
+	ctx context.Context
+}
+
+func (c LambdaConfig) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// EntityOptions are settings that apply to one entity
+type EntityOptions struct {
+	// PageSize overrides the number of items requested per API page
+	PageSize int `json:"PageSize"`
+
+	// MaxPages stops fetching after this many API pages. Zero means no limit.
+	MaxPages int `json:"MaxPages"`
+
+	// SinceLastArchived only saves risk score history newer than what was saved in earlier runs, in a file
+	// per run. The full history is still requested, so it saves no API requests.
+	SinceLastArchived bool `json:"SinceLastArchived"`
+
+	// Format is the output format of the entity's files: "jsonl", "parquet" or "csv". It defaults to the
+	// Format of the run.
+	Format string `json:"Format"`
+
+	// Redact maps the dotted JSON path of a field, e.g. "user.email", to what is done to it in redacted
+	// files: "drop" to empty it, "mask" to hide all but a few characters, or "pseudonymize" to replace it
+	// with a keyed hash. Only text fields can be masked or pseudonymized.
+	Redact map[string]string `json:"Redact"`
+}
+
+// pageOptions returns the paging options for an entity's list endpoint
+func (c LambdaConfig) pageOptions(entity, urlPath string) PageOptions {
+	opts := c.EntityOptions[entity]
+	return PageOptions{
+		Entity:    entity,
+		Path:      urlPath,
+		PageSize:  opts.PageSize,
+		MaxPages:  opts.MaxPages,
+		RawPrefix: c.rawPrefix(entity),
+	}
+}
+
+// objectOptions returns the options for an entity's endpoint that isn't paged
+func (c LambdaConfig) objectOptions(entity, urlPath string, ids ...int) PageOptions {
+	return PageOptions{Entity: entity, Path: urlPath, RawPrefix: c.rawPrefix(entity, ids...)}
+}
+
+// rawPrefix returns the key prefix for raw responses of an entity in this run, or "" if they aren't saved
+func (c LambdaConfig) rawPrefix(entity string, ids ...int) string {
+	if !c.RawResponses {
+		return ""
+	}
+	prefix := "raw/" + entity + "/" + c.runID + "/"
+	for _, id := range ids {
+		prefix += fmt.Sprintf("%v/", id)
+	}
+	return prefix
+}
+
+// objectMetadata returns the user metadata for an object of an entity saved in this run
+func (c LambdaConfig) objectMetadata(entity string) map[string]string {
+	metadata := map[string]string{"archiver-version": version}
+	if c.runID != "" {
+		metadata["run-id"] = c.runID
+	}
+	if entity != "" {
+		metadata["entity"] = entity
+	}
+	return metadata
+}
+
+// redacted returns the config for saving redacted copies to RedactedDestination
+func (c LambdaConfig) redacted() LambdaConfig {
+	c.sink = c.redactedSink
+	c.redactedSink = nil
+	return c
+}
+
+// EntityError is the error from archiving one entity
+type EntityError struct {
+	Entity string
+	Err    error
+}
+
+// RunError lists the entities that failed in a run. The other entities were archived.
+type RunError struct {
+	Errors []EntityError
+}
+
+func (e *RunError) Error() string {
+	names := make([]string, len(e.Errors))
+	details := make([]string, len(e.Errors))
+	for i, entityErr := range e.Errors {
+		names[i] = entityErr.Entity
+		details[i] = entityErr.Entity + ": " + entityErr.Err.Error()
+	}
+	return fmt.Sprintf("%d entities failed (%s) ... %s",
+		len(e.Errors), strings.Join(names, ", "), strings.Join(details, "; "))
+}
+
+func (e *RunError) add(entity string, err error) {
+	log.Printf("error archiving %s ... %s", entity, err)
+	e.Errors = append(e.Errors, EntityError{Entity: entity, Err: err})
+}
+
+// selectedEntities returns the set of entities to archive in this run
+func (c LambdaConfig) selectedEntities() (map[string]bool, error) {
+	selected := map[string]bool{}
+	if len(c.Entities) == 0 {
+		for _, e := range allEntities {
+			if !optInEntities[e] {
+				selected[e] = true
+			}
+		}
+		return selected, nil
+	}
+
+	for _, e := range c.Entities {
+		known := false
+		for _, a := range allEntities {
+			if e == a {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown entity %q, must be one of %s", e, strings.Join(allEntities, ", "))
+		}
+		selected[e] = true
+	}
+	return selected, nil
+}
+
+func (c *LambdaConfig) init() error {
+	if err := getRequiredString(EnvAPIBaseURL, &c.APIBaseURL); err != nil {
+		return err
+	}
+	if err := getRequiredString(EnvAPIAuthToken, &c.APIAuthToken); err != nil {
+		return err
+	}
+	if c.Destination == "" {
+		c.Destination = os.Getenv(EnvDestination)
+	}
+	if c.Destination == "" {
+		if err := getRequiredString(EnvAWSS3Bucket, &c.AWSS3Bucket); err != nil {
+			return err
+		}
+		c.Destination = "s3://" + c.AWSS3Bucket
+	}
+	if err := getOptionalFloat(EnvAPIRequestsPerSecond, &c.APIRequestsPerSecond); err != nil {
+		return err
+	}
+	if err := getOptionalInt(EnvAPIDailyRequestLimit, &c.APIDailyRequestLimit); err != nil {
+		return err
+	}
+	if err := getOptionalInt(EnvRecipientConcurrency, &c.RecipientConcurrency); err != nil {
+		return err
+	}
+	if err := getOptionalBool(EnvRawResponses, &c.RawResponses); err != nil {
+		return err
+	}
+
+	if c.SchemaDriftMode == "" {
+		c.SchemaDriftMode = os.Getenv(EnvSchemaDriftMode)
+	}
+	if c.Compression == "" {
+		c.Compression = os.Getenv(EnvCompression)
+	}
+	if c.Format == "" {
+		c.Format = os.Getenv(EnvFormat)
+	}
+	if err := getOptionalBool(EnvPartitionedKeys, &c.PartitionedKeys); err != nil {
+		return err
+	}
+	if c.KeyTemplate == "" {
+		c.KeyTemplate = os.Getenv(EnvKeyTemplate)
+	}
+	if err := getOptionalBool(EnvForceUpload, &c.ForceUpload); err != nil {
+		return err
+	}
+	if c.ServerSideEncryption == "" {
+		c.ServerSideEncryption = os.Getenv(EnvServerSideEncryption)
+	}
+	if c.SSEKMSKeyID == "" {
+		c.SSEKMSKeyID = os.Getenv(EnvSSEKMSKeyID)
+	}
+	if c.ObjectTags == nil && os.Getenv(EnvObjectTags) != "" {
+		tags, err := parseObjectTags(os.Getenv(EnvObjectTags))
+		if err != nil {
+			return fmt.Errorf("invalid value for environment variable %s: %s", EnvObjectTags, err)
+		}
+		c.ObjectTags = tags
+	}
+	if c.EntityOptions == nil && os.Getenv(EnvEntityOptions) != "" {
+		opts, err := parseEntityOptions(os.Getenv(EnvEntityOptions))
+		if err != nil {
+			return fmt.Errorf("invalid value for environment variable %s: %s", EnvEntityOptions, err)
+		}
+		c.EntityOptions = opts
+	}
+	if c.RedactedDestination == "" {
+		c.RedactedDestination = os.Getenv(EnvRedactedDestination)
+	}
+	if c.PseudonymKey == "" {
+		c.PseudonymKey = os.Getenv(EnvPseudonymKey)
+	}
+	if err := getOptionalFloat(EnvReconciliationFailPercent, &c.ReconciliationFailPercent); err != nil {
+		return err
+	}
+	if err := validateKeyTemplate(c.keyTemplate()); err != nil {
+		return err
+	}
+
+	started := time.Now().UTC()
+	c.runID = started.Format("20060102T150405Z")
+	c.manifest = newRunManifest(c.runID, started)
+	c.reconciliation = newReconciliation()
+	c.integrity = newIntegrityCheck()
+
+	c.limiter = newRateLimiter(c.APIRequestsPerSecond, c.APIDailyRequestLimit)
+
+	drift, err := newDriftReport(c.SchemaDriftMode)
+	if err != nil {
+		return err
+	}
+	c.drift = drift
+
+	codec, err := newCodec(c.Compression)
+	if err != nil {
+		return err
+	}
+	c.codec = codec
+
+	// Catch a bad format before anything is fetched, rather than when the entity is saved
+	for _, entity := range allEntities {
+		if _, err := c.entityFormat(entity); err != nil {
+			return err
+		}
+	}
+	if err := c.validateRedaction(); err != nil {
+		return err
+	}
+
+	s3Options := S3Options{
+		ServerSideEncryption: c.ServerSideEncryption,
+		SSEKMSKeyID:          c.SSEKMSKeyID,
+		Tags:                 c.ObjectTags,
+	}
+	sink, err := newSink(c.Destination, s3Options)
+	if err != nil {
+		return err
+	}
+	c.sink = sink
+	c.stateStore = newSinkStateStore(sink)
+
+	if c.RedactedDestination != "" {
+		if c.RedactedDestination == c.Destination {
+			return errors.New("the redacted destination must differ from the destination")
+		}
+		redactedSink, err := newSink(c.RedactedDestination, s3Options)
+		if err != nil {
+			return err
+		}
+		c.redactedSink = redactedSink
+	}
+
+	return loadAPIUsage(*c)
+}
+
+func getRequiredString(envKey string, configEntry *string) error {
+	if *configEntry != "" {
+		return nil
+	}
+
+	value := os.Getenv(envKey)
+	if value == "" {
+		return fmt.Errorf("required value missing for environment variable %s", envKey)
+	}
+	*configEntry = value
+
+	return nil
+}
+
+func getOptionalInt(envKey string, configEntry *int) error {
+	if *configEntry != 0 {
+		return nil
+	}
+
+	value := os.Getenv(envKey)
+	if value == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for environment variable %s: %s", envKey, err)
+	}
+	*configEntry = i
+
+	return nil
+}
+
+func getOptionalFloat(envKey string, configEntry *float64) error {
+	if *configEntry != 0 {
+		return nil
+	}
+
+	value := os.Getenv(envKey)
+	if value == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value for environment variable %s: %s", envKey, err)
+	}
+	*configEntry = f
+
+	return nil
+}
+
+func getOptionalBool(envKey string, configEntry *bool) error {
+	if *configEntry {
+		return nil
+	}
+
+	value := os.Getenv(envKey)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for environment variable %s: %s", envKey, err)
+	}
+	*configEntry = b
+
+	return nil
+}
+
+// callAPI makes a GET request to the KnowBe4 API, waiting on the rate limiter and retrying with backoff on
+// transport errors, 429 and 5xx responses. The attempts and the reading of the returned body share the
+// retry time budget. If the request can't be completed, the error is a *RetryError.
+func callAPI(urlPath string, config LambdaConfig, queryParams map[string]string) (*http.Response, error) {
+	url := config.APIBaseURL + "/" + urlPath
+	policy := newRetryPolicy(config)
+	start := time.Now()
+	deadline := start.Add(policy.maxElapsedTime)
+
+	retryErr := &RetryError{URL: url}
+
+	for n := 1; ; n++ {
+		if err := config.limiter.wait(config.context()); err != nil {
+			return nil, err
+		}
+
+		resp, err := callAPIOnce(url, config, queryParams, deadline)
+		if err == nil {
+			return resp, nil
+		}
+
+		attempt := APIAttempt{Number: n, Err: err}
+		var statusErr *APIStatusError
+		if errors.As(err, &statusErr) {
+			attempt.StatusCode = statusErr.StatusCode
+		}
+
+		switch {
+		case config.context().Err() != nil:
+			retryErr.Reason = "cancelled"
+		case errors.Is(err, context.DeadlineExceeded):
+			retryErr.Reason = "retry time budget exhausted"
+		case !isRetryable(err):
+			retryErr.Reason = "not retryable"
+		case n >= policy.maxAttempts:
+			retryErr.Reason = "max attempts reached"
+		}
+		if retryErr.Reason != "" {
+			retryErr.Attempts = append(retryErr.Attempts, attempt)
+			return nil, retryErr
+		}
+
+		wait := policy.backoff(n)
+		if statusErr != nil && statusErr.RetryAfter > 0 {
+			wait = statusErr.RetryAfter
+		}
+		if time.Since(start)+wait > policy.maxElapsedTime {
+			retryErr.Attempts = append(retryErr.Attempts, attempt)
+			retryErr.Reason = "retry time budget exhausted"
+			return nil, retryErr
+		}
+
+		attempt.Wait = wait
+		retryErr.Attempts = append(retryErr.Attempts, attempt)
+		log.Printf("API request to %s failed (attempt %d), retrying in %s ... %s", url, n, wait, err)
+		if err := retrySleep(config.context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// callAPIOnce makes a single GET request to the KnowBe4 API, giving up at deadline. The deadline also
+// applies to reading the returned body.
+func callAPIOnce(url string, config LambdaConfig, queryParams map[string]string, deadline time.Time) (*http.Response, error) {
+	ctx, cancel := context.WithDeadline(config.context(), deadline)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error preparing http request: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+config.APIAuthToken)
+	req.Header.Set("Accept", "application/json")
+
+	// Add query parameters
+	q := req.URL.Query()
+	for key, val := range queryParams {
+		q.Add(key, val)
+	}
+	req.URL.RawQuery = q.Encode()
+
+	client := &http.Client{}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error making http request: %w", err)
+	} else if resp.StatusCode >= 300 {
+		resBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		return nil, &APIStatusError{
+			URL:        url,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       resBody,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the context of a request when its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+func getAllSecurityTests(config LambdaConfig) ([]KnowBe4SecurityTest, error) {
+	return getAll[KnowBe4SecurityTest](config, config.pageOptions(entitySecurityTests, securityTestURLPath))
+}
+
+// getGroupSummaries lists the ID and name of every group, without keeping the rest of each group
+func getGroupSummaries(config LambdaConfig) ([]GroupSummary, error) {
+	var groups []GroupSummary
+	err := Paginate(config, PageOptions{Entity: entityGroups, Path: groupsURLPath}, func(p Page[KnowBe4Group]) error {
+		for _, g := range p.Items {
+			groups = append(groups, GroupSummary{GroupID: g.Id, Name: g.Name})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// recipientsFileName returns the JSON Lines key for the recipients of a security test. Keys from a template
+// are dated by the test's start, so a test that is saved again while it is open replaces its recipients
+// rather than adding them to another day's partition.
+func (c LambdaConfig) recipientsFileName(secTest KnowBe4SecurityTest) string {
+	date := time.Now()
+	if secTest.StartedAt != nil {
+		date = *secTest.StartedAt
+	}
+	return c.objectKeyAt(entityRecipients, fmt.Sprintf("%s%v.jsonl", recipientsFilenamePrefix, secTest.PstID),
+		secTest.PstID, date)
+}
+
+// saveRecipientsForSecTest saves the recipients of a security test and adds them to the run's checks. It
+// returns the key they were saved at.
+func saveRecipientsForSecTest(secTest KnowBe4SecurityTest, config LambdaConfig) (string, error) {
+	secTestID := secTest.PstID
+	filename := config.recipientsFileName(secTest)
+	opts := config.pageOptions(entityRecipients, fmt.Sprintf(recipientsURLPath, secTestID))
+	opts.RawPrefix = config.rawPrefix(entityRecipients, secTestID)
+
+	counts := newRecipientCounts()
+	var userRefs []referenceID
+	prepare := func(r *KnowBe4Recipient) {
+		counts.count(r)
+		userRefs = append(userRefs, referenceID{id: r.RecipientID, ref: r.User.ID})
+	}
+	if _, err := savePages(config, opts, filename, prepare); err != nil {
+		return "", fmt.Errorf("error saving recipients for security test %v ... %s", secTestID, err)
+	}
+	config.reconciliation.add(secTest, counts)
+	config.integrity.addIDs(entityRecipients)
+	config.integrity.addReferences(entityRecipients, "user.id", userRefs...)
+
+	return config.recordKey(entityRecipients, filename), nil
+}
+
+// saveRecipientsAsync saves the recipients of each security test, marking each one saved in state if
+// state is not nil
+func saveRecipientsAsync(config LambdaConfig, secTests []KnowBe4SecurityTest, state *ArchiveState) error {
+	ids := make([]int, len(secTests))
+	tests := map[int]KnowBe4SecurityTest{}
+	for i := range secTests {
+		ids[i] = secTests[i].PstID
+		tests[secTests[i].PstID] = secTests[i]
+	}
+
+	batchErr := runWorkerPool(config.context(), "security tests", ids, config.RecipientConcurrency, maxErrorsAllowed,
+		func(ctx context.Context, id int) error {
+			jobConfig := config
+			jobConfig.ctx = ctx
+			key, err := saveRecipientsForSecTest(tests[id], jobConfig)
+			if err != nil {
+				log.Print(err.Error())
+				return err
+			}
+			if state != nil {
+				state.markArchived(id, tests[id].Status, key, time.Now().UTC())
+			}
+			return nil
+		})
+
+	if batchErr == nil {
+		log.Printf("saved %d test recipient files", len(ids))
+		return nil
+	}
+
+	log.Printf("saved %d test recipient files with %d errors",
+		len(ids)-len(batchErr.Errors)-batchErr.Skipped, len(batchErr.Errors))
+
+	return batchErr
+}
+
+// saveList saves a list of objects to the sink, in the output format of the entity
+func saveList[T any](config LambdaConfig, entity string, items []T, fileName string) error {
+	return saveRecords(config, entity, fileName, reflect.TypeOf(items).Elem(),
+		func(write func(v interface{}) error) (int, error) {
+			for i := range items {
+				if err := write(items[i]); err != nil {
+					return 0, err
+				}
+			}
+			return config.manifest.entityPages(entity), nil
+		})
+}
+
+// savePages streams every page of a list endpoint to one object, calling prepare on each item if it isn't
+// nil. It returns the number of items saved.
+func savePages[T any](config LambdaConfig, opts PageOptions, fileName string, prepare func(*T)) (int, error) {
+	count := 0
+	err := saveRecords(config, opts.Entity, fileName, reflect.TypeOf((*T)(nil)).Elem(),
+		func(write func(v interface{}) error) (int, error) {
+			pages := 0
+			err := Paginate(config, opts, func(p Page[T]) error {
+				pages++
+				for i := range p.Items {
+					if prepare != nil {
+						prepare(&p.Items[i])
+					}
+					if err := write(p.Items[i]); err != nil {
+						return err
+					}
+				}
+				count += len(p.Items)
+				return nil
+			})
+			return pages, err
+		})
+	return count, err
+}
+
+// saveForEachID streams the values fetch writes for each of the ids to one object, and returns how many
+// were written. If any ID fails, the rest are cancelled and nothing is saved.
+func saveForEachID[T any](config LambdaConfig, entity string, ids []int, fileName string,
+	fetch func(jobConfig LambdaConfig, id int, write func(v T) error) error) (int, error) {
+	count := 0
+	err := saveRecords(config, entity, fileName, reflect.TypeOf((*T)(nil)).Elem(),
+		func(writeRecord func(v interface{}) error) (int, error) {
+			var mutex sync.Mutex
+			write := func(v T) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				count++
+				return writeRecord(v)
+			}
+
+			batchErr := runWorkerPool(config.context(), entity, ids, config.RecipientConcurrency, 1,
+				func(ctx context.Context, id int) error {
+					jobConfig := config
+					jobConfig.ctx = ctx
+					return fetch(jobConfig, id, write)
+				})
+			if batchErr != nil {
+				return 0, batchErr
+			}
+			return config.manifest.entityPages(entity), nil
+		})
+	return count, err
+}
+
+// streamToSink saves the output of write, compressed with config.codec, as an object in the sink. The
+// output goes through a temporary file rather than straight to the sink (see spoolToSink), so a file
+// takes disk space in the temporary directory rather than memory while it is written.
+func streamToSink(config LambdaConfig, fileName string, opts PutOptions,
+	write func(w io.Writer) error) (ManifestFile, error) {
+	opts.ContentEncoding = config.codec.contentEncoding
+	return spoolToSink(config, fileName+config.codec.suffix, opts, func(w io.Writer) error {
+		return config.codec.compress(w, write)
+	})
+}
+
+// spoolToSink writes the output of write to a temporary file, so its checksum is known before it is
+// uploaded, and skips the upload if the object already there has the same content
+func spoolToSink(config LambdaConfig, key string, opts PutOptions, write func(w io.Writer) error) (ManifestFile, error) {
+	tmp, err := os.CreateTemp("", "knowbe4-archiver-*")
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("error creating temporary file for %s ... %s", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hw := newHashingWriter(tmp)
+	bw := bufio.NewWriter(hw)
+	if err := write(bw); err != nil {
+		return ManifestFile{}, err
+	}
+	if err := bw.Flush(); err != nil {
+		return ManifestFile{}, fmt.Errorf("error writing temporary file for %s ... %s", key, err)
+	}
+	file := ManifestFile{Key: key, Bytes: hw.n, SHA256: hw.sum()}
+
+	if !config.ForceUpload {
+		// If the existing object can't be checked, it is simply replaced. Old objects are uploaded again so
+		// the bucket's expiration rule doesn't remove them while they are current.
+		existing, err := config.sink.Stat(key)
+		if err == nil && existing.SHA256 == file.SHA256 && time.Since(existing.LastModified) < unchangedMaxAge {
+			log.Printf("%s is unchanged, not uploading it again", key)
+			file.Unchanged = true
+			return file, nil
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return ManifestFile{}, fmt.Errorf("error reading temporary file for %s ... %s", key, err)
+	}
+	metadata := map[string]string{sha256MetadataKey: file.SHA256}
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	opts.Metadata = metadata
+	if err := config.sink.Put(key, tmp, opts); err != nil {
+		return ManifestFile{}, err
+	}
+	return file, nil
+}
+
+// Handler runs the archiver for one Lambda invocation
+func Handler(ctx context.Context, config LambdaConfig) error {
+	if err := config.init(); err != nil {
+		return err
+	}
+	config.ctx = ctx
+
+	return archive(config)
+}
+
+// archive saves each of the selected entities. A failure in one doesn't stop the others, and is
+// returned in a *RunError.
+func archive(config LambdaConfig) error {
+	selected, err := config.selectedEntities()
+	if err != nil {
+		return err
+	}
+
+	// Each selected entity needs at least one request, so none is started without enough budget for all
+	if err := config.limiter.checkBudget(len(selected)); err != nil {
+		return fmt.Errorf("not enough API budget left for the selected entities ... %w", err)
+	}
+
+	runErr := &RunError{}
+
+	if selected[entityAccount] {
+		if err := getAndSaveAccount(config); err != nil {
+			runErr.add(entityAccount, err)
+		}
+	}
+
+	if selected[entityAccountRiskScores] {
+		if err := getAndSaveAccountRiskScores(config); err != nil {
+			runErr.add(entityAccountRiskScores, err)
+		}
+	}
+
+	if selected[entityCampaigns] {
+		if err := getAndSaveCampaigns(config); err != nil {
+			runErr.add(entityCampaigns, err)
+		}
+	}
+
+	var groups []GroupSummary
+	if selected[entityGroups] {
+		if groups, err = getAndSaveGroups(config); err != nil {
+			runErr.add(entityGroups, err)
+		}
+	}
+
+	if selected[entityGroupMembers] {
+		if err := getAndSaveGroupMembers(config, groups); err != nil {
+			runErr.add(entityGroupMembers, err)
+		}
+	}
+
+	var userIDs []int
+	if selected[entityUsers] {
+		if userIDs, err = getAndSaveUsers(config); err != nil {
+			runErr.add(entityUsers, err)
+		}
+	}
+
+	if selected[entityTrainingCampaigns] {
+		if err := getAndSaveTrainingCampaigns(config); err != nil {
+			runErr.add(entityTrainingCampaigns, err)
+		}
+	}
+
+	if selected[entityTrainingEnrollments] {
+		if err := getAndSaveTrainingEnrollments(config); err != nil {
+			runErr.add(entityTrainingEnrollments, err)
+		}
+	}
+
+	if selected[entityTrainingPolicies] {
+		if err := getAndSaveTrainingPolicies(config); err != nil {
+			runErr.add(entityTrainingPolicies, err)
+		}
+	}
+
+	if selected[entityTrainingStorePurchases] {
+		if err := getAndSaveTrainingStorePurchases(config); err != nil {
+			runErr.add(entityTrainingStorePurchases, err)
+		}
+	}
+
+	if selected[entitySecurityTests] || selected[entityRecipients] {
+		stResults, err := getAllSecurityTests(config)
+		if err != nil {
+			err = errors.New("error getting security tests from api ..." + err.Error())
+			for _, entity := range []string{entitySecurityTests, entityRecipients} {
+				if selected[entity] {
+					runErr.add(entity, err)
+				}
+			}
+		} else {
+			if selected[entitySecurityTests] {
+				if err := saveSecurityTests(config, stResults); err != nil {
+					runErr.add(entitySecurityTests, err)
+				}
+			}
+			if selected[entityRecipients] {
+				if err := saveRecipients(config, stResults); err != nil {
+					runErr.add(entityRecipients, err)
+				}
+			}
+		}
+	}
+
+	// Risk scores take one request per group or user, so they come last to leave the budget for the rest
+	if selected[entityGroupRiskScores] {
+		if err := getAndSaveGroupRiskScores(config, groups); err != nil {
+			runErr.add(entityGroupRiskScores, err)
+		}
+	}
+
+	if selected[entityUserRiskScores] {
+		if err := getAndSaveUserRiskScores(config, userIDs); err != nil {
+			runErr.add(entityUserRiskScores, err)
+		}
+	}
+
+	if err := saveAPIUsage(config); err != nil {
+		runErr.add("state", err)
+	}
+
+	config.integrity.finish(config, runErr)
+	config.reconciliation.finish(config, runErr)
+	config.drift.finish(config, runErr)
+
+	// The manifest is saved last, so downstream jobs can take it to mean the run is complete
+	if err := config.manifest.finish(config, selected, runErr); err != nil {
+		runErr.add("manifest", err)
+	}
+
+	if len(runErr.Errors) > 0 {
+		return runErr
+	}
+	return nil
+}
+
+// saveRecipients saves the recipients of the security tests that need them, or of all of them on a full run
+func saveRecipients(config LambdaConfig, stResults []KnowBe4SecurityTest) error {
+	// Progress is saved even if some tests fail, so they are the only ones retried next time
+	return updateState(config, func(state *ArchiveState) error {
+		toArchive := stResults
+		if !config.FullRun {
+			toArchive = nil
+			saved := state.savedRecipients(config)
+			for _, st := range stResults {
+				if state.needsRecipients(config, st, saved) {
+					toArchive = append(toArchive, st)
+				}
+			}
+		}
+		log.Printf("%d of %d security tests need recipients saved", len(toArchive), len(stResults))
+
+		count := config.MaxFileCount
+		if count == 0 || count > len(toArchive) {
+			count = len(toArchive)
+		}
+
+		// Each security test needs at least one request for its recipients
+		if err := config.limiter.checkBudget(count); err != nil {
+			return errors.New("not enough API budget left to save recipients ... " + err.Error())
+		}
+
+		return saveRecipientsAsync(config, toArchive[:count], state)
+	})
+}
+
+func saveSecurityTests(config LambdaConfig, stResults []KnowBe4SecurityTest) error {
+	fileName := config.objectKey(entitySecurityTests, phishingTestsFilename, 0)
+	if err := saveList(config, entitySecurityTests, stResults, fileName); err != nil {
+		return errors.New("error saving security test results ..." + err.Error())
+	}
+
+	var campaignRefs, groupRefs []referenceID
+	config.integrity.addIDs(entitySecurityTests)
+	for _, st := range stResults {
+		campaignRefs = append(campaignRefs, referenceID{id: st.PstID, ref: st.CampaignID})
+		for _, g := range st.Groups {
+			groupRefs = append(groupRefs, referenceID{id: st.PstID, ref: g.GroupID})
+		}
+	}
+	config.integrity.addReferences(entitySecurityTests, "campaign_id", campaignRefs...)
+	config.integrity.addReferences(entitySecurityTests, "groups.group_id", groupRefs...)
+
+	log.Printf("saved %d security tests", len(stResults))
+	return nil
+}
+
+func getAndSaveCampaigns(config LambdaConfig) error {
+	var ids []int
+	var groupRefs []referenceID
+	collect := func(c *KnowBe4Campaign) {
+		ids = append(ids, c.CampaignID)
+		for _, g := range c.Groups {
+			groupRefs = append(groupRefs, referenceID{id: c.CampaignID, ref: g.GroupID})
+		}
+	}
+
+	count, err := savePages(config, config.pageOptions(entityCampaigns, campaignsURLPath),
+		config.objectKey(entityCampaigns, campaignsFilename, 0), collect)
+	if err != nil {
+		return errors.New("error saving campaigns ..." + err.Error())
+	}
+	config.integrity.addIDs(entityCampaigns, ids...)
+	config.integrity.addReferences(entityCampaigns, "groups.group_id", groupRefs...)
+	log.Printf("saved %d campaigns", count)
+	return nil
+}
+
+// getAndSaveGroups saves all groups and returns the ID and name of each
+func getAndSaveGroups(config LambdaConfig) ([]GroupSummary, error) {
+	var groups []GroupSummary
+	collect := func(g *KnowBe4Group) {
+		groups = append(groups, GroupSummary{GroupID: g.Id, Name: g.Name})
+	}
+
+	count, err := savePages(config, config.pageOptions(entityGroups, groupsURLPath),
+		config.objectKey(entityGroups, groupsFilename, 0), collect)
+	if err != nil {
+		return nil, errors.New("error saving groups ..." + err.Error())
+	}
+	for _, g := range groups {
+		config.integrity.addIDs(entityGroups, g.GroupID)
+	}
+
+	log.Printf("saved %d groups", count)
+	return groups, nil
+}
+
+// getAndSaveGroupMembers saves a dated snapshot of the members of every group, fetching the groups first
+// if groups is nil. Groups deleted since they were listed are skipped. Nothing is saved if any other
+// group fails.
+func getAndSaveGroupMembers(config LambdaConfig, groups []GroupSummary) error {
+	if groups == nil {
+		var err error
+		if groups, err = getGroupSummaries(config); err != nil {
+			return errors.New("error getting groups from KnowBe4 ..." + err.Error())
+		}
+	}
+
+	currentTime := time.Now().Format("2006-01-02")
+	names := map[int]string{}
+	ids := make([]int, len(groups))
+	for i, g := range groups {
+		ids[i] = g.GroupID
+		names[g.GroupID] = g.Name
+	}
+
+	fileName := config.objectKey(entityGroupMembers, groupMembersPrefix+currentTime+".jsonl", 0)
+	count, err := saveForEachID(config, entityGroupMembers, ids, fileName,
+		func(jobConfig LambdaConfig, id int, write func(v KnowBe4GroupMember) error) error {
+			opts := config.pageOptions(entityGroupMembers, fmt.Sprintf(groupMembersURLPath, id))
+			opts.RawPrefix = config.rawPrefix(entityGroupMembers, id)
+			err := Paginate(jobConfig, opts, func(p Page[KnowBe4User]) error {
+				for _, u := range p.Items {
+					member := KnowBe4GroupMember{
+						GroupID:      id,
+						GroupName:    names[id],
+						UserID:       u.Id,
+						Email:        u.Email,
+						SnapshotDate: currentTime,
+					}
+					if err := write(member); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			var statusErr *APIStatusError
+			if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+				// The group was deleted since it was listed
+				log.Printf("skipping members of group %d, it was not found", id)
+				return nil
+			}
+			return err
+		})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("saved %d members of %d groups", count, len(groups))
+	return nil
+}
+
+func getAndSaveTrainingCampaigns(config LambdaConfig) error {
+	opts := config.pageOptions(entityTrainingCampaigns, trainingCampaignsURLPath)
+	count, err := savePages[KnowBe4TrainingCampaign](config, opts,
+		config.objectKey(entityTrainingCampaigns, trainingCampaignsFilename, 0), nil)
+	if err != nil {
+		return errors.New("error saving training campaigns ..." + err.Error())
+	}
+	log.Printf("saved %d training campaigns", count)
+	return nil
+}
+
+func getAndSaveTrainingEnrollments(config LambdaConfig) error {
+	opts := config.pageOptions(entityTrainingEnrollments, trainingEnrollmentsURLPath)
+	count, err := savePages[KnowBe4TrainingEnrollment](config, opts,
+		config.objectKey(entityTrainingEnrollments, trainingEnrollmentsFilename, 0), nil)
+	if err != nil {
+		return errors.New("error saving training enrollments ..." + err.Error())
+	}
+	log.Printf("saved %d training enrollments", count)
+	return nil
+}
+
+func getAndSaveTrainingPolicies(config LambdaConfig) error {
+	currentTime := time.Now().Format("2006-01-02")
+
+	setSnapshotDate := func(p *KnowBe4Policy) {
+		p.SnapshotDate = currentTime
+	}
+
+	opts := config.pageOptions(entityTrainingPolicies, trainingPoliciesURLPath)
+	fileName := config.objectKey(entityTrainingPolicies, trainingPoliciesFilenamePrefix+currentTime+".jsonl", 0)
+	count, err := savePages(config, opts, fileName, setSnapshotDate)
+	if err != nil {
+		return errors.New("error saving training policies ..." + err.Error())
+	}
+
+	log.Printf("saved %d training policies", count)
+	return nil
+}
+
+func getAndSaveTrainingStorePurchases(config LambdaConfig) error {
+	currentTime := time.Now().Format("2006-01-02")
+
+	setSnapshotDate := func(p *KnowBe4StorePurchase) {
+		p.SnapshotDate = currentTime
+	}
+
+	opts := config.pageOptions(entityTrainingStorePurchases, trainingStorePurchasesURLPath)
+	fileName := config.objectKey(entityTrainingStorePurchases,
+		trainingStorePurchasesFilenamePrefix+currentTime+".jsonl", 0)
+	count, err := savePages(config, opts, fileName, setSnapshotDate)
+	if err != nil {
+		return errors.New("error saving training store purchases ..." + err.Error())
+	}
+
+	log.Printf("saved %d training store purchases", count)
+	return nil
+}
+
+// getAndSaveUsers saves a dated snapshot of all users and returns their IDs
+func getAndSaveUsers(config LambdaConfig) ([]int, error) {
+	currentTime := time.Now().Format("2006-01-02")
+
+	var ids []int
+	var groupRefs []referenceID
+	setSnapshotDate := func(u *KnowBe4User) {
+		u.SnapshotDate = currentTime
+		ids = append(ids, u.Id)
+		for _, g := range u.Groups {
+			groupRefs = append(groupRefs, referenceID{id: u.Id, ref: g})
+		}
+	}
+
+	opts := config.pageOptions(entityUsers, usersURLPath)
+	fileName := config.objectKey(entityUsers, usersFilenamePrefix+currentTime+".jsonl", 0)
+	count, err := savePages(config, opts, fileName, setSnapshotDate)
+	if err != nil {
+		return nil, errors.New("error saving users ..." + err.Error())
+	}
+	config.integrity.addIDs(entityUsers, ids...)
+	config.integrity.addReferences(entityUsers, "groups", groupRefs...)
+
+	log.Printf("saved %d users", count)
+	return ids, nil
+}
@@ -0,0 +1,460 @@
+package archiver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getAllSecurityTests(t *testing.T) {
+	assert := require.New(t)
+
+	testURL := getTestServer("/"+securityTestURLPath, "["+exampleSecurityTest+"]")
+
+	var want []KnowBe4SecurityTest
+
+	exBytes := []byte(("[" + exampleSecurityTest + "]"))
+	err := json.Unmarshal(exBytes, &want)
+	assert.NoError(err, "error unmarshalling fixtures")
+
+	got, err := getAllSecurityTests(LambdaConfig{APIBaseURL: testURL})
+	assert.NoError(err)
+
+	assert.Equal(want, got, "bad struct results")
+}
+
+func Test_saveRecipientsForSecTest(t *testing.T) {
+	assert := require.New(t)
+
+	const secTestID = 111
+	path := "/" + fmt.Sprintf(recipientsURLPath, secTestID)
+	testURL := getTestServer(path, "["+exampleRecipient+"]")
+
+	var want []KnowBe4Recipient
+
+	exBytes := []byte(("[" + exampleRecipient + "]"))
+	err := json.Unmarshal(exBytes, &want)
+	assert.NoError(err, "error unmarshalling fixtures")
+
+	sink := newFileSink(t.TempDir())
+	key, err := saveRecipientsForSecTest(KnowBe4SecurityTest{PstID: secTestID}, LambdaConfig{APIBaseURL: testURL, sink: sink})
+	assert.NoError(err)
+	assert.Equal(recipientsFilenamePrefix+"111.jsonl", key)
+
+	assert.Equal(want, readJSONLines[KnowBe4Recipient](t, sink, key), "bad struct results")
+}
+
+func Test_getAndSaveCampaigns(t *testing.T) {
+	assert := require.New(t)
+
+	testURL := getTestServer("/"+campaignsURLPath, exampleCampaigns)
+
+	var want []KnowBe4Campaign
+
+	exBytes := []byte((exampleCampaigns))
+	err := json.Unmarshal(exBytes, &want)
+	assert.NoError(err, "error unmarshalling fixtures")
+
+	sink := newFileSink(t.TempDir())
+	assert.NoError(getAndSaveCampaigns(LambdaConfig{APIBaseURL: testURL, sink: sink}))
+
+	assert.Equal(want, readJSONLines[KnowBe4Campaign](t, sink, campaignsFilename), "bad struct results")
+}
+
+func Test_getGroupSummaries(t *testing.T) {
+	assert := require.New(t)
+
+	testURL := getTestServer("/"+groupsURLPath, exampleGroups)
+
+	var groups []KnowBe4Group
+	err := json.Unmarshal([]byte(exampleGroups), &groups)
+	assert.NoError(err, "error unmarshalling fixtures")
+
+	var want []GroupSummary
+	for _, g := range groups {
+		want = append(want, GroupSummary{GroupID: g.Id, Name: g.Name})
+	}
+
+	got, err := getGroupSummaries(LambdaConfig{APIBaseURL: testURL})
+	assert.NoError(err)
+
+	assert.Equal(want, got, "bad struct results")
+}
+
+func Test_getAndSaveTraining(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+trainingCampaignsURLPath, getTestHandler(exampleTrainingCampaigns))
+	mux.HandleFunc("/"+trainingEnrollmentsURLPath, getTestHandler(exampleTrainingEnrollments))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: server.URL, sink: sink}
+
+	assert.NoError(getAndSaveTrainingCampaigns(config))
+	assert.NoError(getAndSaveTrainingEnrollments(config))
+
+	objects, err := sink.List("training/")
+	assert.NoError(err)
+	assert.Len(objects, 2)
+	assert.Equal(trainingCampaignsFilename, objects[0].Key)
+	assert.Equal(trainingEnrollmentsFilename, objects[1].Key)
+
+	var wantCampaigns []KnowBe4TrainingCampaign
+	assert.NoError(json.Unmarshal([]byte(exampleTrainingCampaigns), &wantCampaigns), "error unmarshalling fixtures")
+	campaigns := readJSONLines[KnowBe4TrainingCampaign](t, sink, trainingCampaignsFilename)
+	assert.Equal(wantCampaigns, campaigns, "bad struct results")
+	assert.Equal(7, campaigns[0].Content[0].StorePurchaseID)
+
+	var wantEnrollments []KnowBe4TrainingEnrollment
+	assert.NoError(json.Unmarshal([]byte(exampleTrainingEnrollments), &wantEnrollments), "error unmarshalling fixtures")
+	enrollments := readJSONLines[KnowBe4TrainingEnrollment](t, sink, trainingEnrollmentsFilename)
+	assert.Equal(wantEnrollments, enrollments, "bad struct results")
+	assert.Equal("s_thomas@kb4-demo.com", enrollments[0].User.Email)
+	assert.Nil(enrollments[0].CompletionDate)
+}
+
+func Test_getAndSavePoliciesAndStorePurchases(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+trainingPoliciesURLPath, getTestHandler(examplePolicies))
+	mux.HandleFunc("/"+trainingStorePurchasesURLPath, getTestHandler(exampleStorePurchases))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: server.URL, sink: sink}
+
+	assert.NoError(getAndSaveTrainingPolicies(config))
+	assert.NoError(getAndSaveTrainingStorePurchases(config))
+
+	currentTime := time.Now().Format("2006-01-02")
+	r, err := sink.Get(trainingStorePurchasesFilenamePrefix + currentTime + ".jsonl")
+	assert.NoError(err)
+	defer r.Close()
+
+	var got KnowBe4StorePurchase
+	assert.NoError(json.NewDecoder(r).Decode(&got))
+	assert.Equal(7, got.StorePurchaseID)
+	assert.Nil(got.RetirementDate)
+	assert.Equal(currentTime, got.SnapshotDate)
+
+	_, err = sink.Stat(trainingPoliciesFilenamePrefix + currentTime + ".jsonl")
+	assert.NoError(err)
+}
+
+func Test_getAndSaveGroupMembers(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+groupsURLPath, getTestHandler(exampleGroups))
+	mux.HandleFunc("/"+fmt.Sprintf(groupMembersURLPath, 2184841), getTestHandler("[]"))
+	mux.HandleFunc("/"+fmt.Sprintf(groupMembersURLPath, 1629520), getTestHandler(exampleUsers))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: server.URL, sink: sink}
+
+	groups, err := getAndSaveGroups(config)
+	assert.NoError(err)
+	assert.Equal([]GroupSummary{{GroupID: 2184841, Name: "!SawPhish Monitors"}, {GroupID: 1629520, Name: "!SG - Advanced Phishing"}}, groups)
+
+	assert.NoError(getAndSaveGroupMembers(config, groups))
+
+	currentTime := time.Now().Format("2006-01-02")
+	r, err := sink.Get(groupMembersPrefix + currentTime + ".jsonl")
+	assert.NoError(err)
+	defer r.Close()
+
+	var got KnowBe4GroupMember
+	assert.NoError(json.NewDecoder(r).Decode(&got))
+	assert.Equal(KnowBe4GroupMember{
+		GroupID:      1629520,
+		GroupName:    "!SG - Advanced Phishing",
+		UserID:       667542,
+		Email:        "wmarcoux@kb4-demo.com",
+		SnapshotDate: currentTime,
+	}, got)
+}
+
+func Test_getAndSaveGroupMembers_failure(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+fmt.Sprintf(groupMembersURLPath, 1), getTestHandler(exampleUsers))
+	mux.HandleFunc("/"+fmt.Sprintf(groupMembersURLPath, 2), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: server.URL, RetryMaxAttempts: 1, sink: sink}
+
+	err := getAndSaveGroupMembers(config, []GroupSummary{{GroupID: 1}, {GroupID: 2}})
+	assert.Error(err)
+
+	objects, err := sink.List("groups/members/")
+	assert.NoError(err)
+	assert.Empty(objects, "a partial snapshot should not be saved")
+}
+
+func Test_getAndSaveGroupMembers_skipsNotFound(t *testing.T) {
+	assert := require.New(t)
+
+	// The second group was deleted, so its members get a 404
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+fmt.Sprintf(groupMembersURLPath, 1), getTestHandler(exampleUsers))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: server.URL, sink: sink}
+
+	assert.NoError(getAndSaveGroupMembers(config, []GroupSummary{{GroupID: 1}, {GroupID: 2}}))
+
+	currentTime := time.Now().Format("2006-01-02")
+	members := readJSONLines[KnowBe4GroupMember](t, sink, groupMembersPrefix+currentTime+".jsonl")
+	assert.Len(members, 1)
+	assert.Equal(1, members[0].GroupID)
+}
+
+func Test_getAndSaveUsers(t *testing.T) {
+	assert := require.New(t)
+
+	testURL := getTestServer("/"+usersURLPath, exampleUsers)
+	sink := newFileSink(t.TempDir())
+
+	ids, err := getAndSaveUsers(LambdaConfig{APIBaseURL: testURL, sink: sink})
+	assert.NoError(err)
+	assert.Equal([]int{667542}, ids)
+
+	objects, err := sink.List("users/")
+	assert.NoError(err)
+	assert.Len(objects, 1)
+
+	r, err := sink.Get(objects[0].Key)
+	assert.NoError(err)
+	defer r.Close()
+
+	var got KnowBe4User
+	assert.NoError(json.NewDecoder(r).Decode(&got))
+	assert.Equal(time.Now().Format("2006-01-02"), got.SnapshotDate)
+	assert.Equal(objects[0].Key, usersFilenamePrefix+got.SnapshotDate+".jsonl")
+
+	var want []KnowBe4User
+	assert.NoError(json.Unmarshal([]byte(exampleUsers), &want), "error unmarshalling fixtures")
+	want[0].SnapshotDate = got.SnapshotDate
+	assert.Equal(want, readJSONLines[KnowBe4User](t, sink, objects[0].Key), "bad struct results")
+}
+
+func Test_archive_continuesAfterFailure(t *testing.T) {
+	assert := require.New(t)
+
+	// Campaigns and users are not served, so they get a 404
+	testURL := getTestServer("/"+groupsURLPath, exampleGroups)
+	sink := newFileSink(t.TempDir())
+
+	config := LambdaConfig{
+		APIBaseURL: testURL,
+		Entities:   []string{entityCampaigns, entityGroups, entityUsers},
+		sink:       sink,
+	}
+	err := archive(config)
+
+	var runErr *RunError
+	assert.True(errors.As(err, &runErr))
+	assert.Len(runErr.Errors, 2)
+	assert.Equal(entityCampaigns, runErr.Errors[0].Entity)
+	assert.Equal(entityUsers, runErr.Errors[1].Entity)
+	assert.Contains(err.Error(), "2 entities failed (campaigns, users)")
+
+	_, err = sink.Stat(groupsFilename)
+	assert.NoError(err, "groups should be saved even though campaigns failed")
+}
+
+func Test_LambdaConfig_event(t *testing.T) {
+	assert := require.New(t)
+
+	event := `{"Entities": ["users"], "EntityOptions": {"users": {"PageSize": 100, "MaxPages": 2}}}`
+
+	var config LambdaConfig
+	assert.NoError(json.Unmarshal([]byte(event), &config))
+
+	selected, err := config.selectedEntities()
+	assert.NoError(err)
+	assert.Equal(map[string]bool{entityUsers: true}, selected)
+
+	// Risk scores are only archived when they are asked for
+	selected, err = LambdaConfig{}.selectedEntities()
+	assert.NoError(err)
+	assert.Len(selected, len(allEntities)-len(optInEntities))
+	assert.True(selected[entityUsers])
+	assert.NotContains(selected, entityUserRiskScores)
+	assert.NotContains(selected, entityGroupRiskScores)
+
+	assert.Equal(PageOptions{Entity: entityUsers, Path: usersURLPath, PageSize: 100, MaxPages: 2},
+		config.pageOptions(entityUsers, usersURLPath))
+	assert.Equal(PageOptions{Entity: entityGroups, Path: groupsURLPath}, config.pageOptions(entityGroups, groupsURLPath))
+}
+
+// readJSONLines decodes every line of a JSON Lines object
+func readJSONLines[T any](t *testing.T, sink Sink, key string) []T {
+	r, err := sink.Get(key)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var values []T
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		var v T
+		require.NoError(t, dec.Decode(&v))
+		values = append(values, v)
+	}
+	return values
+}
+
+func getTestHandler(responseBody string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		jsonBytes, err := json.Marshal(responseBody)
+		if err != nil {
+			panic("Unable to marshal fixture results, error:" + err.Error())
+		}
+
+		w.WriteHeader(200)
+		w.Header().Set("content-type", "application/json")
+
+		s, _ := strconv.Unquote(string(jsonBytes))
+		_, _ = fmt.Fprintf(w, s)
+	}
+}
+
+func getTestServer(path, response string) string {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	handler := getTestHandler(response)
+
+	mux.HandleFunc(path, handler)
+
+	return server.URL
+}
+
+func Test_saveList(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []GroupSummary
+		want  string
+	}{
+		{
+			name:  "empty list",
+			input: []GroupSummary{},
+			want:  "",
+		},
+		{
+			name:  "single struct",
+			input: []GroupSummary{{GroupID: 1, Name: "name"}},
+			want:  `{"group_id":1,"name":"name"}` + "\n",
+		},
+		{
+			name:  "two struct items",
+			input: []GroupSummary{{GroupID: 1, Name: "name 1"}, {GroupID: 2, Name: "name 2"}},
+			want:  `{"group_id":1,"name":"name 1"}` + "\n" + `{"group_id":2,"name":"name 2"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newFileSink(t.TempDir())
+			require.NoError(t, saveList(LambdaConfig{sink: sink}, entityGroups, tt.input, "test.jsonl"))
+
+			r, err := sink.Get("test.jsonl")
+			require.NoError(t, err)
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(data))
+		})
+	}
+}
+
+func Test_saveList_skipsUnchanged(t *testing.T) {
+	assert := require.New(t)
+
+	sink, fake := newTestS3Sink(t, "")
+	manifest := newRunManifest("run1", time.Now())
+	config := LambdaConfig{sink: sink, manifest: manifest}
+	groups := []GroupSummary{{GroupID: 1, Name: "name"}}
+
+	assert.NoError(saveList(config, entityGroups, groups, groupsFilename))
+	assert.Equal(1, fake.putCount())
+	o, _ := fake.object(groupsFilename)
+	sum := sha256.Sum256(o.body)
+	assert.Equal(hex.EncodeToString(sum[:]), o.header.Get("X-Amz-Meta-Sha256"))
+
+	assert.NoError(saveList(config, entityGroups, groups, groupsFilename))
+	assert.Equal(1, fake.putCount(), "the same content should not be uploaded again")
+
+	groups[0].Name = "new name"
+	assert.NoError(saveList(config, entityGroups, groups, groupsFilename))
+	assert.Equal(2, fake.putCount())
+
+	config.ForceUpload = true
+	assert.NoError(saveList(config, entityGroups, groups, groupsFilename))
+	assert.Equal(3, fake.putCount())
+
+	var unchanged []bool
+	for _, f := range manifest.manifest.Files {
+		unchanged = append(unchanged, f.Unchanged)
+	}
+	assert.Equal([]bool{false, true, false, false}, unchanged)
+}
+
+func Test_saveList_refreshesOldUnchanged(t *testing.T) {
+	assert := require.New(t)
+
+	dir := t.TempDir()
+	sink := newFileSink(dir)
+	config := LambdaConfig{sink: sink}
+	groups := []GroupSummary{{GroupID: 1, Name: "name"}}
+
+	assert.NoError(saveList(config, entityGroups, groups, groupsFilename))
+	old := time.Now().Add(-unchangedMaxAge - time.Hour)
+	assert.NoError(os.Chtimes(sink.path(groupsFilename), old, old))
+
+	assert.NoError(saveList(config, entityGroups, groups, groupsFilename))
+	info, err := sink.Stat(groupsFilename)
+	assert.NoError(err)
+	assert.WithinDuration(time.Now(), info.LastModified, time.Minute, "an old object should be uploaded again")
+}
+
+func Test_saveList_metadata(t *testing.T) {
+	assert := require.New(t)
+
+	sink, fake := newTestS3Sink(t, "")
+	config := LambdaConfig{sink: sink, runID: "20261016T061000Z"}
+
+	assert.NoError(saveList(config, entityGroups, []GroupSummary{{GroupID: 1}, {GroupID: 2}}, groupsFilename))
+
+	o, ok := fake.object(groupsFilename)
+	assert.True(ok)
+	assert.Equal("20261016T061000Z", o.header.Get("X-Amz-Meta-Run-Id"))
+	assert.Equal(entityGroups, o.header.Get("X-Amz-Meta-Entity"))
+	assert.Equal("2", o.header.Get("X-Amz-Meta-Records"))
+	assert.Equal(version, o.header.Get("X-Amz-Meta-Archiver-Version"))
+	assert.NotEmpty(o.header.Get("X-Amz-Meta-Sha256"))
+}
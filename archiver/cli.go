@@ -0,0 +1,260 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const cliUsage = `Usage: knowbe4-archiver <command> [flags]
+
+Commands:
+  run          archive data from KnowBe4, like the scheduled Lambda run
+  recipients   archive the recipients of specific security tests
+  list-tests   print the security tests KnowBe4 has on record
+
+Run "knowbe4-archiver <command> -h" for the flags of each command. Any setting not given as a flag
+is read from the same environment variables the Lambda function uses.
+`
+
+// RunCLI runs one command line subcommand and returns the process exit code
+func RunCLI(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(stderr, cliUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch args[0] {
+	case "run":
+		err = runCommand(ctx, args[1:], stderr)
+	case "recipients":
+		err = recipientsCommand(ctx, args[1:], stderr)
+	case "list-tests":
+		err = listTestsCommand(ctx, args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], cliUsage)
+		return 2
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error: "+err.Error())
+		return 1
+	}
+	return 0
+}
+
+func runCommand(ctx context.Context, args []string, stderr io.Writer) error {
+	var config LambdaConfig
+	fs := newConfigFlagSet("run", &config, stderr)
+	only := fs.String("only", "", "comma-separated entities to archive ("+strings.Join(allEntities, ", ")+")")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *only != "" {
+		config.Entities = splitList(*only)
+	}
+
+	if err := initCLIConfig(ctx, &config); err != nil {
+		return err
+	}
+	if err := archive(config); err != nil {
+		return err
+	}
+
+	log.Printf("Success saving to %s\n", config.Destination)
+	return nil
+}
+
+func recipientsCommand(ctx context.Context, args []string, stderr io.Writer) error {
+	var config LambdaConfig
+	fs := newConfigFlagSet("recipients", &config, stderr)
+	pstIDs := fs.String("pst-id", "", "comma-separated IDs of the security tests (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ids, err := parseIDs(*pstIDs)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return errors.New("at least one --pst-id is required")
+	}
+
+	if err := initCLIConfig(ctx, &config); err != nil {
+		return err
+	}
+
+	// Backfills don't touch the saved state, so the next scheduled run behaves as it would have anyway.
+	// They don't save run reports either, and the tests only have their IDs, so aren't reconciled.
+	config.reconciliation = nil
+	config.integrity = nil
+	secTests := make([]KnowBe4SecurityTest, len(ids))
+	for i, id := range ids {
+		secTests[i].PstID = id
+	}
+	err = saveRecipientsAsync(config, secTests, nil)
+	if usageErr := saveAPIUsage(config); err == nil {
+		err = usageErr
+	}
+	return err
+}
+
+func listTestsCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var config LambdaConfig
+	fs := newConfigFlagSet("list-tests", &config, stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := initCLIConfig(ctx, &config); err != nil {
+		return err
+	}
+
+	tests, err := getAllSecurityTests(config)
+	if err != nil {
+		return err
+	}
+	if err := saveAPIUsage(config); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PST ID\tCAMPAIGN ID\tSTATUS\tSTARTED\tNAME")
+	for _, t := range tests {
+		started := ""
+		if t.StartedAt != nil {
+			started = t.StartedAt.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", t.PstID, t.CampaignID, t.Status, started, t.Name)
+	}
+	return w.Flush()
+}
+
+// newConfigFlagSet makes a flag set for a subcommand with a flag for each LambdaConfig setting
+func newConfigFlagSet(name string, config *LambdaConfig, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	fs.StringVar(&config.APIBaseURL, "api-base-url", "", "KnowBe4 API base URL (env "+EnvAPIBaseURL+")")
+	fs.StringVar(&config.APIAuthToken, "api-auth-token", "", "KnowBe4 API token (env "+EnvAPIAuthToken+")")
+	fs.StringVar(&config.AWSS3Bucket, "bucket", "", "S3 bucket to archive to (env "+EnvAWSS3Bucket+")")
+	fs.StringVar(&config.Destination, "destination", "",
+		"s3://bucket/prefix or file:///directory to archive to, instead of --bucket (env "+EnvDestination+")")
+	fs.Func("entity-options", `per-entity settings as JSON, e.g. {"users": {"PageSize": 250}}, or a JSON file (env `+EnvEntityOptions+`)`,
+		func(s string) error {
+			opts, err := parseEntityOptions(s)
+			config.EntityOptions = opts
+			return err
+		})
+	fs.IntVar(&config.MaxFileCount, "max-file-count", 0, "most security tests to save recipients for (0 for all)")
+	fs.IntVar(&config.RetryMaxAttempts, "retry-max-attempts", 0, "most times to try each API request (default 5)")
+	fs.IntVar(&config.RetryMaxElapsedSeconds, "retry-max-elapsed-seconds", 0,
+		"most seconds to spend retrying each API request (default 120)")
+	fs.Float64Var(&config.APIRequestsPerSecond, "requests-per-second", 0,
+		"average API requests per second (default 4, env "+EnvAPIRequestsPerSecond+")")
+	fs.IntVar(&config.APIDailyRequestLimit, "daily-request-limit", 0,
+		"API requests this run may use today, 0 for no limit (env "+EnvAPIDailyRequestLimit+")")
+	fs.IntVar(&config.RecipientConcurrency, "recipient-concurrency", 0,
+		"security tests to fetch recipients for at once (default 5, env "+EnvRecipientConcurrency+")")
+	fs.BoolVar(&config.FullRun, "full", false, "save recipients of every security test, ignoring saved state")
+	fs.BoolVar(&config.RawResponses, "raw", false, "also save the exact body of every API response (env "+EnvRawResponses+")")
+	fs.StringVar(&config.Compression, "compression", "",
+		"none, gzip or zstd: how to compress archived files (env "+EnvCompression+")")
+	fs.StringVar(&config.Format, "format", "",
+		"jsonl, parquet or csv: the format of archived files (env "+EnvFormat+")")
+	fs.BoolVar(&config.PartitionedKeys, "partitioned", false,
+		"save files under keys like entity=users/dt=2026-10-16/part-0000.jsonl (env "+EnvPartitionedKeys+")")
+	fs.StringVar(&config.KeyTemplate, "key-template", "",
+		"layout of the keys of archived files, implies --partitioned (env "+EnvKeyTemplate+")")
+	fs.BoolVar(&config.ForceUpload, "force-upload", false,
+		"upload every file, even if it hasn't changed since it was last saved (env "+EnvForceUpload+")")
+	fs.StringVar(&config.ServerSideEncryption, "sse", "",
+		"AES256 or aws:kms: how S3 encrypts archived objects (env "+EnvServerSideEncryption+")")
+	fs.StringVar(&config.SSEKMSKeyID, "sse-kms-key-id", "",
+		"KMS key for aws:kms encryption (env "+EnvSSEKMSKeyID+")")
+	fs.Func("object-tags", "key=value,... tags for every object saved to S3 (env "+EnvObjectTags+")",
+		func(s string) error {
+			tags, err := parseObjectTags(s)
+			config.ObjectTags = tags
+			return err
+		})
+	fs.StringVar(&config.RedactedDestination, "redacted-destination", "",
+		"s3:// or file:// URL to save redacted copies of archived files to (env "+EnvRedactedDestination+")")
+	fs.StringVar(&config.PseudonymKey, "pseudonym-key", "",
+		"HMAC key for pseudonymized fields (env "+EnvPseudonymKey+")")
+	fs.Float64Var(&config.ReconciliationFailPercent, "reconciliation-fail-percent", 0,
+		"fail recipients if more than this percent of security tests don't match them (env "+
+			EnvReconciliationFailPercent+")")
+	fs.StringVar(&config.SchemaDriftMode, "schema-drift", "",
+		"off, warn or strict: what to do when API responses don't match the archived types (env "+EnvSchemaDriftMode+")")
+
+	return fs
+}
+
+func initCLIConfig(ctx context.Context, config *LambdaConfig) error {
+	if err := config.init(); err != nil {
+		return err
+	}
+	config.ctx = ctx
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseEntityOptions reads EntityOptions given as a JSON object or as the path of a file holding one
+func parseEntityOptions(s string) (map[string]EntityOptions, error) {
+	b := []byte(s)
+	if !strings.HasPrefix(strings.TrimSpace(s), "{") {
+		var err error
+		if b, err = os.ReadFile(s); err != nil {
+			return nil, fmt.Errorf("error reading entity options ... %s", err)
+		}
+	}
+
+	var opts map[string]EntityOptions
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&opts); err != nil {
+		return nil, fmt.Errorf("invalid entity options ... %s", err)
+	}
+	return opts, nil
+}
+
+func parseIDs(s string) ([]int, error) {
+	var ids []int
+	for _, item := range splitList(s) {
+		id, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", item)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,147 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RunCLI_usage(t *testing.T) {
+	assert := require.New(t)
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(2, RunCLI(nil, &stdout, &stderr))
+	assert.Contains(stderr.String(), "Usage")
+
+	stderr.Reset()
+	assert.Equal(2, RunCLI([]string{"bogus"}, &stdout, &stderr))
+	assert.Contains(stderr.String(), `unknown command "bogus"`)
+}
+
+func Test_RunCLI_listTests(t *testing.T) {
+	assert := require.New(t)
+
+	testURL := getTestServer("/"+securityTestURLPath, "["+exampleSecurityTest+"]")
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{"list-tests", "--api-base-url", testURL, "--api-auth-token", "abc",
+		"--destination", "file://" + t.TempDir()}, &stdout, &stderr)
+	assert.Equal(0, code, stderr.String())
+	assert.Contains(stdout.String(), "PST ID")
+	assert.Regexp(`16142\s+3423\s+Closed\s+2019-04-02\s+Corporate Test`, stdout.String())
+}
+
+func Test_RunCLI_recipients(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	for _, id := range []int{111, 222} {
+		mux.HandleFunc("/"+fmt.Sprintf(recipientsURLPath, id), getTestHandler("["+exampleRecipient+"]"))
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{"recipients", "--pst-id", "111,222", "--api-base-url", server.URL,
+		"--api-auth-token", "abc", "--destination", "file://" + dir}, &stdout, &stderr)
+	assert.Equal(0, code, stderr.String())
+
+	objects, err := newFileSink(dir).List(recipientsFilenamePrefix)
+	assert.NoError(err)
+	assert.Len(objects, 2)
+	assert.Equal(recipientsFilenamePrefix+"111.jsonl", objects[0].Key)
+
+	_, err = newFileSink(dir).Stat(stateFilename)
+	assert.Error(err, "recipients command should not save state")
+}
+
+func Test_RunCLI_recipientsRequiresID(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{"recipients", "--pst-id", "x"}, &stdout, &stderr)
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr.String(), `invalid ID "x"`)
+}
+
+func Test_RunCLI_runOnly(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+usersURLPath, getTestHandler(exampleUsers))
+	mux.HandleFunc("/"+groupsURLPath, getTestHandler(exampleGroups))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "out")
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{"run", "--only", "users, groups", "--api-base-url", server.URL,
+		"--api-auth-token", "abc", "--destination", "file://" + dir}, &stdout, &stderr)
+	assert.Equal(0, code, stderr.String())
+
+	objects, err := newFileSink(dir).List("")
+	assert.NoError(err)
+	assert.Len(objects, 7, "groups, users, the manifest, the latest manifest pointers and the integrity report")
+	assert.Equal(groupsFilename, objects[0].Key)
+	assert.Equal(latestManifestKey, objects[2].Key)
+	assert.Equal(latestEntityManifestPrefix+entityGroups+".json", objects[3].Key)
+	assert.Contains(objects[5].Key, integrityReportPrefix)
+
+	stderr.Reset()
+	code = RunCLI([]string{"run", "--only", "nope", "--api-base-url", server.URL,
+		"--api-auth-token", "abc", "--destination", "file://" + dir}, &stdout, &stderr)
+	assert.Equal(1, code)
+	assert.Contains(stderr.String(), `unknown entity "nope"`)
+}
+
+func Test_parseEntityOptions(t *testing.T) {
+	assert := require.New(t)
+
+	want := map[string]EntityOptions{"users": {PageSize: 250}}
+
+	opts, err := parseEntityOptions(`{"users": {"PageSize": 250}}`)
+	assert.NoError(err)
+	assert.Equal(want, opts)
+
+	path := filepath.Join(t.TempDir(), "options.json")
+	assert.NoError(os.WriteFile(path, []byte(`{"users": {"PageSize": 250}}`), 0o600))
+	opts, err = parseEntityOptions(path)
+	assert.NoError(err)
+	assert.Equal(want, opts)
+
+	_, err = parseEntityOptions(`{"users": {"PageSiz": 250}}`)
+	assert.Error(err)
+	assert.Contains(err.Error(), "invalid entity options")
+
+	_, err = parseEntityOptions(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(err)
+	assert.Contains(err.Error(), "error reading entity options")
+}
+
+func Test_RunCLI_entityOptions(t *testing.T) {
+	assert := require.New(t)
+
+	var pageSizes []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+usersURLPath, func(w http.ResponseWriter, r *http.Request) {
+		pageSizes = append(pageSizes, r.URL.Query().Get("per_page"))
+		_, _ = w.Write([]byte(exampleUsers))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{"run", "--only", "users", "--entity-options", `{"users": {"PageSize": 250}}`,
+		"--api-base-url", server.URL, "--api-auth-token", "abc", "--destination", "file://" + t.TempDir()},
+		&stdout, &stderr)
+	assert.Equal(0, code, stderr.String())
+	assert.NotEmpty(pageSizes)
+	assert.Equal("250", pageSizes[0])
+}
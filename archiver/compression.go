@@ -0,0 +1,90 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+
+	zstdWindowSize = 1 << 20
+)
+
+// codec compresses archived objects. The zero codec leaves them as they are.
+type codec struct {
+	// suffix is added to the key of each object, e.g. ".gz"
+	suffix string
+
+	// contentEncoding is set as the Content-Encoding of each object
+	contentEncoding string
+
+	// parquetCodec is the Parquet CompressionCodec for the pages of Parquet files, which are compressed
+	// inside the file rather than as a whole
+	parquetCodec parquet.CompressionCodec
+
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+var codecs = map[string]codec{
+	compressionNone: {parquetCodec: parquet.CompressionCodec_UNCOMPRESSED},
+	compressionGzip: {
+		suffix:          ".gz",
+		contentEncoding: "gzip",
+		parquetCodec:    parquet.CompressionCodec_GZIP,
+		newWriter:       func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	},
+	compressionZstd: {
+		suffix:          ".zst",
+		contentEncoding: "zstd",
+		parquetCodec:    parquet.CompressionCodec_ZSTD,
+		newWriter:       newZstdWriter,
+	},
+}
+
+// newCodec returns the codec with the given name. An empty name means no compression.
+func newCodec(name string) (codec, error) {
+	if name == "" {
+		return codec{}, nil
+	}
+
+	c, ok := codecs[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(codecs))
+		for n := range codecs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return codec{}, fmt.Errorf("unknown compression %q, must be one of %s", name, strings.Join(names, ", "))
+	}
+	return c, nil
+}
+
+// compress passes the output of write through the codec's compressor, if it has one
+func (c codec) compress(w io.Writer, write func(w io.Writer) error) error {
+	if c.newWriter == nil {
+		return write(w)
+	}
+
+	cw, err := c.newWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := write(cw); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// newZstdWriter makes a zstd encoder using one goroutine and a small window, to fit in a small Lambda
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdWindowSize))
+}
@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newCodec(t *testing.T) {
+	c, err := newCodec("")
+	require.NoError(t, err)
+	require.Equal(t, "", c.suffix)
+
+	c, err = newCodec("GZIP")
+	require.NoError(t, err)
+	require.Equal(t, ".gz", c.suffix)
+
+	_, err = newCodec("brotli")
+	require.Error(t, err)
+}
+
+func Test_streamToSink_gzip(t *testing.T) {
+	assert := require.New(t)
+
+	codec, err := newCodec(compressionGzip)
+	assert.NoError(err)
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{sink: sink, codec: codec}
+
+	assert.NoError(saveList(config, "test", []map[string]int{{"id": 1}, {"id": 2}}, "test.jsonl"))
+
+	r, err := sink.Get("test.jsonl.gz")
+	assert.NoError(err)
+	defer r.Close()
+
+	gr, err := gzip.NewReader(r)
+	assert.NoError(err)
+	data, err := io.ReadAll(gr)
+	assert.NoError(err)
+	assert.Equal("{\"id\":1}\n{\"id\":2}\n", string(data))
+}
+
+func Test_streamToSink_zstd(t *testing.T) {
+	assert := require.New(t)
+
+	codec, err := newCodec(compressionZstd)
+	assert.NoError(err)
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{sink: sink, codec: codec}
+
+	rows := make([]map[string]int, 4000)
+	for i := range rows {
+		rows[i] = map[string]int{"recipient_id": i, "pst_id": 456}
+	}
+	assert.NoError(saveList(config, "test", rows, "test.jsonl"))
+
+	r, err := sink.Get("test.jsonl.zst")
+	assert.NoError(err)
+	defer r.Close()
+
+	zr, err := zstd.NewReader(r)
+	assert.NoError(err)
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	assert.NoError(err)
+	assert.Equal(4000, strings.Count(string(data), "\n"))
+	assert.True(strings.HasPrefix(string(data), `{"pst_id":456,"recipient_id":0}`))
+}
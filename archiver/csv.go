@@ -0,0 +1,184 @@
+package archiver
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvListSeparator joins the items of a slice field into one CSV cell
+const csvListSeparator = "|"
+
+// csvColumn is one column of a CSV file, read from the struct field reached through index
+type csvColumn struct {
+	header string
+	index  []int
+}
+
+// csvColumns flattens a struct type into columns in field order, with dotted headers like "user.email"
+func csvColumns(t reflect.Type) ([]csvColumn, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't write %s as CSV, rows must be structs", t)
+	}
+	return appendCSVColumns(nil, t, "", nil), nil
+}
+
+func appendCSVColumns(columns []csvColumn, t reflect.Type, prefix string, index []int) []csvColumn {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fieldIndex := append(append([]int{}, index...), i)
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != timeType {
+			columns = appendCSVColumns(columns, ft, prefix+name+".", fieldIndex)
+			continue
+		}
+		columns = append(columns, csvColumn{header: prefix + name, index: fieldIndex})
+	}
+	return columns
+}
+
+// csvWriter writes values of one struct type as CSV rows, after a header row
+type csvWriter struct {
+	w       *csv.Writer
+	rowType reflect.Type
+	columns []csvColumn
+	row     []string
+}
+
+func newCSVWriter(w io.Writer, rowType reflect.Type) (*csvWriter, error) {
+	columns, err := csvColumns(rowType)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &csvWriter{w: csv.NewWriter(w), rowType: rowType, columns: columns, row: make([]string, len(columns))}
+	for i, col := range columns {
+		c.row[i] = col.header
+	}
+	if err := c.w.Write(c.row); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Write adds one row, which must be of the writer's row type or a pointer to it
+func (c *csvWriter) Write(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Type() != c.rowType {
+		return fmt.Errorf("can't write %s to a CSV file of %s", rv.Type(), c.rowType)
+	}
+
+	for i, col := range c.columns {
+		cell, err := formatCSVCell(csvField(rv, col.index))
+		if err != nil {
+			return fmt.Errorf("error formatting %s ... %s", col.header, err)
+		}
+		c.row[i] = cell
+	}
+	return c.w.Write(c.row)
+}
+
+// Close flushes the rows still buffered. It does not close the underlying writer.
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvField follows index from v, returning an invalid Value if it passes through a nil pointer
+func csvField(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// formatCSVCell formats one value for a cell. Slices are joined with csvListSeparator, objects are JSON.
+// A cell that isn't a number and starts with a character spreadsheets read as a formula is prefixed
+// with ', so opening the file can't run anything.
+func formatCSVCell(v reflect.Value) (string, error) {
+	cell, err := formatCSVValue(v)
+	if err != nil || isCSVNumber(v) || cell == "" || !strings.ContainsRune(csvFormulaPrefixes, rune(cell[0])) {
+		return cell, err
+	}
+	return "'" + cell, nil
+}
+
+// csvFormulaPrefixes are the first characters that make spreadsheets read a cell as a formula
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvListEscaper escapes the separator in the items of a list, and the backslash used to escape it
+var csvListEscaper = strings.NewReplacer(`\`, `\\`, csvListSeparator, `\`+csvListSeparator)
+
+func formatCSVValue(v reflect.Value) (string, error) {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "", nil
+	}
+
+	switch {
+	case v.Type() == timeType:
+		return v.Interface().(time.Time).Format(time.RFC3339), nil
+	case v.Kind() == reflect.String:
+		return v.String(), nil
+	case v.Kind() == reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		items := make([]string, v.Len())
+		for i := range items {
+			item, err := formatCSVValue(v.Index(i))
+			if err != nil {
+				return "", err
+			}
+			items[i] = csvListEscaper.Replace(item)
+		}
+		return strings.Join(items, csvListSeparator), nil
+	default:
+		b, err := json.Marshal(v.Interface())
+		return string(b), err
+	}
+}
+
+// isCSVNumber reports whether v holds a single number, which is written as it is even if it is negative
+func isCSVNumber(v reflect.Value) bool {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return false
+	}
+	k := v.Kind()
+	return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+}
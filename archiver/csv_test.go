@@ -0,0 +1,145 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_csvWriter(t *testing.T) {
+	assert := require.New(t)
+
+	var recipient KnowBe4Recipient
+	assert.NoError(json.Unmarshal([]byte(exampleRecipient), &recipient))
+
+	var buf bytes.Buffer
+	cw, err := newCSVWriter(&buf, reflect.TypeOf(recipient))
+	assert.NoError(err)
+	assert.NoError(cw.Write(&recipient))
+	assert.NoError(cw.Close())
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(err)
+	assert.Len(rows, 2)
+	assert.Equal([]string{"recipient_id", "pst_id", "user.id", "user.active_directory_guid", "user.first_name",
+		"user.last_name", "user.email", "template.id", "template.name", "scheduled_at"}, rows[0][:10])
+
+	got := map[string]string{}
+	for i, header := range rows[0] {
+		got[header] = rows[1][i]
+	}
+	assert.Equal("3077742", got["recipient_id"])
+	assert.Equal("", got["user.active_directory_guid"])
+	assert.Equal("bob.r@kb4-demo.com", got["user.email"])
+	assert.Equal("Your Amazon Order", got["template.name"])
+	assert.Equal("2019-04-02T15:02:38Z", got["scheduled_at"])
+	assert.Equal("", got["bounced_at"])
+
+	assert.Error(cw.Write(GroupSummary{}))
+}
+
+func Test_csvWriter_noRows(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	cw, err := newCSVWriter(&buf, reflect.TypeOf(GroupSummary{}))
+	assert.NoError(err)
+	assert.NoError(cw.Close())
+	assert.Equal("group_id,name\n", buf.String())
+
+	_, err = newCSVWriter(&buf, reflect.TypeOf(""))
+	assert.Error(err)
+}
+
+func Test_formatCSVCell(t *testing.T) {
+	when := time.Date(2020, 11, 6, 12, 30, 0, 0, time.FixedZone("", -5*60*60))
+	var missing *time.Time
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{name: "nil pointer", value: missing, want: ""},
+		{name: "time", value: &when, want: "2020-11-06T12:30:00-05:00"},
+		{name: "float", value: 14.235, want: "14.235"},
+		{name: "bool", value: false, want: "false"},
+		{name: "ints", value: []int{3264, 3265}, want: "3264|3265"},
+		{name: "empty list", value: []string{}, want: ""},
+		{name: "strings", value: []string{"a@example.com"}, want: "a@example.com"},
+		{name: "structs", value: []GroupSummary{{GroupID: 1, Name: "A"}, {GroupID: 2, Name: "B"}},
+			want: `{"group_id":1,"name":"A"}|{"group_id":2,"name":"B"}`},
+		{name: "map", value: map[string]int{"a": 1}, want: `{"a":1}`},
+		{name: "formula", value: "=HYPERLINK(\"http://example.com\")", want: `'=HYPERLINK("http://example.com")`},
+		{name: "formula prefixes", value: []string{"+1", "@SUM(A1)"}, want: `'+1|@SUM(A1)`},
+		{name: "negative number", value: -3, want: "-3"},
+		{name: "negative numbers", value: []int{-3, 4}, want: "'-3|4"},
+		{name: "separator in items", value: []string{"a|b", `c\d`}, want: `a\|b|c\\d`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatCSVCell(reflect.ValueOf(tt.value))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getAndSaveUsers_csv(t *testing.T) {
+	assert := require.New(t)
+
+	testURL := getTestServer("/"+usersURLPath, exampleUsers)
+	sink := newFileSink(t.TempDir())
+
+	_, err := getAndSaveUsers(LambdaConfig{APIBaseURL: testURL, Format: formatCSV, sink: sink})
+	assert.NoError(err)
+
+	key := usersFilenamePrefix + time.Now().Format("2006-01-02") + ".csv"
+	r, err := sink.Get(key)
+	assert.NoError(err)
+	defer r.Close()
+
+	rows, err := csv.NewReader(r).ReadAll()
+	assert.NoError(err)
+	assert.Len(rows, 2)
+
+	got := map[string]string{}
+	for i, header := range rows[0] {
+		got[header] = rows[1][i]
+	}
+	assert.Equal("3264", got["groups"])
+	assert.Equal("alias_email@kb4-demo.com", got["aliases"])
+	assert.Equal(`{"risk_score":45.742,"date":"2019-03-12"}`, got["risk_score_history"])
+	assert.Equal("2019-04-02T15:02:38Z", got["joined_on"])
+	assert.Equal("", got["archived_at"])
+}
+
+func Test_LambdaConfig_entityFormat(t *testing.T) {
+	assert := require.New(t)
+
+	config := LambdaConfig{
+		Format:        "CSV",
+		EntityOptions: map[string]EntityOptions{entityUsers: {Format: formatParquet}},
+	}
+
+	format, err := config.entityFormat(entityUsers)
+	assert.NoError(err)
+	assert.Equal(formatParquet, format)
+
+	format, err = config.entityFormat(entityGroups)
+	assert.NoError(err)
+	assert.Equal(formatCSV, format)
+
+	format, err = LambdaConfig{}.entityFormat(entityGroups)
+	assert.NoError(err)
+	assert.Equal(formatJSONLines, format)
+
+	config.Format = "xlsx"
+	_, err = config.entityFormat(entityGroups)
+	assert.Error(err)
+}
@@ -0,0 +1,315 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	driftModeOff    = "off"
+	driftModeWarn   = "warn"
+	driftModeStrict = "strict"
+
+	driftReportPrefix = "drift/knowbe4_schema_drift_"
+)
+
+// driftIgnoredFields are set by the archiver rather than KnowBe4, so they are never in a response
+var driftIgnoredFields = map[string]bool{
+	"snapshot_date": true,
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// EntityDrift lists the fields where an entity's API responses differ from the struct they decode into
+type EntityDrift struct {
+	UnknownFields  []string `json:"unknown_fields,omitempty"`
+	MissingFields  []string `json:"missing_fields,omitempty"`
+	TypeMismatches []string `json:"type_mismatches,omitempty"`
+}
+
+func (d EntityDrift) empty() bool {
+	return len(d.UnknownFields) == 0 && len(d.MissingFields) == 0 && len(d.TypeMismatches) == 0
+}
+
+// DriftError is returned for an entity whose responses didn't match its struct, in strict mode
+type DriftError struct {
+	Entity string
+	Drift  EntityDrift
+}
+
+func (e *DriftError) Error() string {
+	var parts []string
+	if len(e.Drift.UnknownFields) > 0 {
+		parts = append(parts, "unknown fields "+strings.Join(e.Drift.UnknownFields, ", "))
+	}
+	if len(e.Drift.MissingFields) > 0 {
+		parts = append(parts, "missing fields "+strings.Join(e.Drift.MissingFields, ", "))
+	}
+	if len(e.Drift.TypeMismatches) > 0 {
+		parts = append(parts, "type mismatches "+strings.Join(e.Drift.TypeMismatches, ", "))
+	}
+	return fmt.Sprintf("schema drift in %s responses ... %s", e.Entity, strings.Join(parts, "; "))
+}
+
+// driftIssues is the set of problems of each kind seen for one entity
+type driftIssues struct {
+	unknown    map[string]bool
+	missing    map[string]bool
+	mismatches map[string]bool
+}
+
+// driftReport collects the schema drift found in every response of a run
+type driftReport struct {
+	mode     string
+	mutex    sync.Mutex
+	entities map[string]*driftIssues
+}
+
+func newDriftReport(mode string) (*driftReport, error) {
+	switch mode {
+	case "", driftModeOff:
+		return nil, nil
+	case driftModeWarn, driftModeStrict:
+		return &driftReport{mode: mode, entities: map[string]*driftIssues{}}, nil
+	default:
+		return nil, fmt.Errorf("invalid schema drift mode %q, must be %s, %s or %s",
+			mode, driftModeOff, driftModeWarn, driftModeStrict)
+	}
+}
+
+// check compares a response body with the type it is decoded into, recording any differences
+func (d *driftReport) check(entity string, t reflect.Type, body []byte) {
+	if d == nil {
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		// Decoding into the struct reports this more usefully
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	issues, ok := d.entities[entity]
+	if !ok {
+		issues = &driftIssues{unknown: map[string]bool{}, missing: map[string]bool{}, mismatches: map[string]bool{}}
+		d.entities[entity] = issues
+	}
+
+	d.walk(entity, issues, "", t, value)
+}
+
+func (d *driftReport) record(entity string, set map[string]bool, kind, issue string) {
+	if set[issue] {
+		return
+	}
+	set[issue] = true
+	if d.mode == driftModeWarn {
+		log.Printf("schema drift in %s responses ... %s %s", entity, kind, issue)
+	}
+}
+
+func (d *driftReport) walk(entity string, issues *driftIssues, path string, t reflect.Type, value interface{}) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if value == nil || t.Kind() == reflect.Interface {
+		return
+	}
+
+	mismatch := func(want string) {
+		d.record(entity, issues.mismatches, "type mismatch",
+			fmt.Sprintf("%s: expected %s, got %s", displayPath(path), want, jsonKind(value)))
+	}
+
+	switch {
+	case t == timeType:
+		if _, ok := value.(string); !ok {
+			mismatch("timestamp string")
+		}
+	case t.Kind() == reflect.Struct:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			mismatch("object")
+			return
+		}
+		d.walkStruct(entity, issues, path, t, obj)
+	case t.Kind() == reflect.Map:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			mismatch("object")
+			return
+		}
+		for k, v := range obj {
+			d.walk(entity, issues, joinPath(path, k), t.Elem(), v)
+		}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			mismatch("array")
+			return
+		}
+		// The items of a page are reported without a prefix, as "email" rather than "[].email"
+		elemPath := path + "[]"
+		if path == "" {
+			elemPath = ""
+		}
+		for _, v := range list {
+			d.walk(entity, issues, elemPath, t.Elem(), v)
+		}
+	case t.Kind() == reflect.String:
+		if _, ok := value.(string); !ok {
+			mismatch("string")
+		}
+	case t.Kind() == reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			mismatch("boolean")
+		}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		n, ok := value.(json.Number)
+		if !ok {
+			mismatch("integer")
+		} else if _, err := n.Int64(); err != nil {
+			mismatch("integer")
+		}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			mismatch("number")
+		}
+	}
+}
+
+func (d *driftReport) walkStruct(entity string, issues *driftIssues, path string, t reflect.Type,
+	obj map[string]interface{}) {
+	known := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		known[name] = true
+
+		v, ok := obj[name]
+		if !ok {
+			if !driftIgnoredFields[name] {
+				d.record(entity, issues.missing, "missing field", joinPath(path, name))
+			}
+			continue
+		}
+		d.walk(entity, issues, joinPath(path, name), field.Type, v)
+	}
+
+	for name := range obj {
+		if !known[name] {
+			d.record(entity, issues.unknown, "unknown field", joinPath(path, name))
+		}
+	}
+}
+
+// results returns the drift found for each entity that had any
+func (d *driftReport) results() map[string]EntityDrift {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	results := map[string]EntityDrift{}
+	for entity, issues := range d.entities {
+		drift := EntityDrift{
+			UnknownFields:  sortedKeys(issues.unknown),
+			MissingFields:  sortedKeys(issues.missing),
+			TypeMismatches: sortedKeys(issues.mismatches),
+		}
+		if !drift.empty() {
+			results[entity] = drift
+		}
+	}
+	return results
+}
+
+// finish saves the drift report for the run. In strict mode, each entity with drift is added to runErr.
+func (d *driftReport) finish(config LambdaConfig, runErr *RunError) {
+	if d == nil {
+		return
+	}
+
+	results := d.results()
+
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err == nil {
+		err = putObject(config, "schema_drift", driftReportPrefix+config.runID+".json", b,
+			PutOptions{ContentType: "application/json"})
+	}
+	if err != nil {
+		runErr.add("schema_drift", fmt.Errorf("error saving schema drift report ... %s", err))
+	}
+
+	if d.mode != driftModeStrict {
+		return
+	}
+
+	entities := make([]string, 0, len(results))
+	for entity := range results {
+		entities = append(entities, entity)
+	}
+	sort.Strings(entities)
+	for _, entity := range entities {
+		runErr.add(entity, &DriftError{Entity: entity, Drift: results[entity]})
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(response)"
+	}
+	return path
+}
+
+func jsonKind(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err != nil {
+			return "number " + v.String()
+		}
+		return "integer"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
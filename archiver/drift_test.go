@@ -0,0 +1,130 @@
+package archiver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type driftTestItem struct {
+	ID      int        `json:"id"`
+	Name    string     `json:"name"`
+	Score   float64    `json:"score"`
+	Tags    []string   `json:"tags"`
+	Created *time.Time `json:"created"`
+	Owner   struct {
+		Email string `json:"email"`
+	} `json:"owner"`
+	SnapshotDate string `json:"snapshot_date"`
+}
+
+func Test_driftReport_check(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want EntityDrift
+	}{
+		{
+			name: "matching",
+			body: `[{"id": 1, "name": "a", "score": 1.5, "tags": ["x"], "created": null, "owner": {"email": "e"}}]`,
+			want: EntityDrift{},
+		},
+		{
+			name: "unknown fields",
+			body: `[{"id": 1, "name": "a", "score": 1, "tags": [], "created": null, "owner": {"email": "e", "phone": "1"}, "new": true}]`,
+			want: EntityDrift{UnknownFields: []string{"new", "owner.phone"}},
+		},
+		{
+			name: "missing fields",
+			body: `[{"id": 1, "score": 1, "tags": [], "created": null, "owner": {}}]`,
+			want: EntityDrift{MissingFields: []string{"name", "owner.email"}},
+		},
+		{
+			name: "type mismatches",
+			body: `[{"id": 1.5, "name": 2, "score": "1", "tags": [3], "created": 4, "owner": []}]`,
+			want: EntityDrift{TypeMismatches: []string{
+				"created: expected timestamp string, got integer",
+				"id: expected integer, got number 1.5",
+				"name: expected string, got integer",
+				"owner: expected object, got array",
+				"score: expected number, got string",
+				"tags[]: expected string, got integer",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDriftReport(driftModeWarn)
+			require.NoError(t, err)
+
+			d.check("items", reflect.TypeOf([]driftTestItem{}), []byte(tt.body))
+			require.Equal(t, tt.want, d.results()["items"])
+		})
+	}
+}
+
+func Test_newDriftReport(t *testing.T) {
+	d, err := newDriftReport("")
+	require.NoError(t, err)
+	require.Nil(t, d)
+
+	d, err = newDriftReport(driftModeOff)
+	require.NoError(t, err)
+	require.Nil(t, d)
+
+	_, err = newDriftReport("loud")
+	require.Error(t, err)
+}
+
+func Test_archive_schemaDrift(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+trainingPoliciesURLPath,
+		getTestHandler(`[{"policy_id": 1, "content_type": "Uploaded Policy", "name": "Policy", "minimum_time": 3,
+			"default_language": "en-us", "status": 1, "archived": false}]`))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, mode := range []string{driftModeWarn, driftModeStrict} {
+		t.Run(mode, func(t *testing.T) {
+			drift, err := newDriftReport(mode)
+			assert.NoError(err)
+
+			sink := newFileSink(t.TempDir())
+			config := LambdaConfig{
+				APIBaseURL: server.URL,
+				Entities:   []string{entityTrainingPolicies},
+				runID:      "run1",
+				drift:      drift,
+				sink:       sink,
+			}
+
+			err = archive(config)
+			if mode == driftModeStrict {
+				var runErr *RunError
+				assert.True(errors.As(err, &runErr))
+				assert.Len(runErr.Errors, 1)
+				assert.Equal(entityTrainingPolicies, runErr.Errors[0].Entity)
+			} else {
+				assert.NoError(err)
+			}
+
+			r, err := sink.Get(driftReportPrefix + "run1.json")
+			assert.NoError(err)
+			defer r.Close()
+
+			var report map[string]EntityDrift
+			assert.NoError(json.NewDecoder(r).Decode(&report))
+			assert.Equal(map[string]EntityDrift{
+				entityTrainingPolicies: {UnknownFields: []string{"archived"}},
+			}, report)
+		})
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package archiver
 
 const exampleSecurityTest = `{
     "campaign_id": 3423,
@@ -219,3 +219,109 @@ const exampleUsers = `[
     "custom_date_2": null
     }
 ]`
+
+const exampleTrainingCampaigns = `[
+  {
+    "campaign_id": 4261,
+    "name": "Annual Training",
+    "groups": [
+      {
+        "group_id": 0,
+        "name": "All Users"
+      }
+    ],
+    "status": "Completed",
+    "content": [
+      {
+        "store_purchase_id": 7,
+        "content_type": "Store Purchase",
+        "name": "2019 Kevin Mitnick Security Awareness Training",
+        "description": "This fully interactive module shows how attackers exploit common security mistakes.",
+        "type": "Training Module",
+        "duration": 45,
+        "retired": false,
+        "retirement_date": null,
+        "publish_date": "2019-04-02T15:02:38.000Z",
+        "publisher": "KnowBe4",
+        "purchase_date": "2019-04-02T15:02:38.000Z",
+        "policy_url": "https://www.yourcompany.com/employees/acceptableusepolicy.html"
+      }
+    ],
+    "duration_type": "Specific End Date",
+    "start_date": "2019-04-02T15:02:38.000Z",
+    "end_date": "2019-04-12T15:02:38.000Z",
+    "relative_duration": "",
+    "auto_enroll": true,
+    "allow_multiple_enrollments": false,
+    "completion_percentage": 42.5
+  }
+]`
+
+const exampleTrainingEnrollments = `[
+  {
+    "enrollment_id": 1425526,
+    "content_type": "Uploaded Policy",
+    "module_name": "Acceptable Use Policy",
+    "user": {
+      "id": 796742,
+      "first_name": "Sarah",
+      "last_name": "Thomas",
+      "email": "s_thomas@kb4-demo.com"
+    },
+    "campaign_name": "New Employee Policies",
+    "enrollment_date": "2019-04-02T15:02:38.000Z",
+    "start_date": "2019-04-02T15:02:38.000Z",
+    "completion_date": null,
+    "status": "In Progress",
+    "time_spent": 2340,
+    "policy_acknowledged": false
+  }
+]`
+
+const exampleAccount = `{
+  "name": "KB4-Demo",
+  "type": "paid",
+  "domains": [
+    "kb4-demo.com"
+  ],
+  "admins": [
+    {
+      "id": 974278,
+      "first_name": "Grace",
+      "last_name": "O'Malley",
+      "email": "grace.o@kb4-demo.com"
+    }
+  ],
+  "subscription_level": "Diamond",
+  "subscription_end_date": "2021-03-16",
+  "number_of_seats": 25,
+  "current_risk_score": 45.742
+}`
+
+const exampleStorePurchases = `[
+  {
+    "store_purchase_id": 7,
+    "content_type": "Store Purchase",
+    "name": "Common Threats",
+    "description": "Learn about the most common threats.",
+    "type": "Training Module",
+    "duration": 20,
+    "retired": false,
+    "retirement_date": null,
+    "publish_date": "2018-01-09T20:13:24.000Z",
+    "publisher": "KnowBe4",
+    "purchase_date": "2019-12-17T14:45:05.000Z",
+    "policy_url": "https://www.example.com"
+  }
+]`
+
+const examplePolicies = `[
+  {
+    "policy_id": 1,
+    "content_type": "Uploaded Policy",
+    "name": "Security Awareness Policy",
+    "minimum_time": 3,
+    "default_language": "en-us",
+    "status": 1
+  }
+]`
@@ -0,0 +1,178 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSONLines = "jsonl"
+	formatParquet   = "parquet"
+	formatCSV       = "csv"
+
+	jsonLinesExtension = ".jsonl"
+)
+
+// recordWriter writes archived values in one output format
+type recordWriter interface {
+	Write(v interface{}) error
+	Close() error
+}
+
+type jsonLinesWriter struct {
+	enc *json.Encoder
+}
+
+func (j jsonLinesWriter) Write(v interface{}) error {
+	return j.enc.Encode(v)
+}
+
+func (j jsonLinesWriter) Close() error {
+	return nil
+}
+
+// entityFormat returns the output format of an entity, falling back to the run's format and JSON Lines
+func (c LambdaConfig) entityFormat(entity string) (string, error) {
+	format := c.EntityOptions[entity].Format
+	if format == "" {
+		format = c.Format
+	}
+
+	switch format = strings.ToLower(format); format {
+	case "", formatJSONLines:
+		return formatJSONLines, nil
+	case formatParquet, formatCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format %q for %s, must be %s, %s or %s", format, entity, formatJSONLines,
+			formatParquet, formatCSV)
+	}
+}
+
+// saveRecords saves the values fill writes as one object in the entity's format, redacted or with a
+// redacted copy, and adds it to the run manifest. fill returns the number of API pages used.
+func saveRecords(config LambdaConfig, entity, fileName string, rowType reflect.Type,
+	fill func(write func(v interface{}) error) (int, error)) error {
+	format, err := config.entityFormat(entity)
+	if err != nil {
+		return err
+	}
+	redactor, err := config.entityRedactor(entity, rowType)
+	if err != nil {
+		return err
+	}
+
+	// The record count is added to the metadata once fill is done, which is before the upload starts
+	metadata := config.objectMetadata(entity)
+
+	// Values are written one at a time, even by saveForEachID, so this needs no lock
+	var records int64
+	var pages int
+	countedFill := func(write func(v interface{}) error) error {
+		var err error
+		pages, err = fill(func(v interface{}) error {
+			records++
+			return write(v)
+		})
+		metadata["records"] = strconv.FormatInt(records, 10)
+		return err
+	}
+
+	opts := PutOptions{Metadata: metadata}
+	var file, redactedFile ManifestFile
+	if config.redactedSink == nil {
+		file, err = writeRecordObject(config, format, fileName, rowType, opts, func(rw recordWriter) error {
+			return countedFill(func(v interface{}) error {
+				redacted, err := redactor.redactFor(format, v)
+				if err != nil {
+					return err
+				}
+				return rw.Write(redacted)
+			})
+		})
+	} else {
+		// The redacted copy is written inside the full one, so it is uploaded first
+		file, err = writeRecordObject(config, format, fileName, rowType, opts, func(full recordWriter) error {
+			var err error
+			redactedFile, err = writeRecordObject(config.redacted(), format, fileName, rowType, opts,
+				func(redacted recordWriter) error {
+					return countedFill(func(v interface{}) error {
+						if err := full.Write(v); err != nil {
+							return err
+						}
+						redactedValue, err := redactor.redactFor(format, v)
+						if err != nil {
+							return err
+						}
+						return redacted.Write(redactedValue)
+					})
+				})
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	file.Entity, file.Records, file.Pages = entity, records, pages
+	config.manifest.addFile(file)
+	if config.redactedSink != nil {
+		redactedFile.Entity, redactedFile.Records, redactedFile.Pages = entity, records, pages
+		redactedFile.RedactedCopy = true
+		config.manifest.addFile(redactedFile)
+	}
+	return nil
+}
+
+// recordKey returns the key saveRecords saves the records of an entity at, for a JSON Lines fileName
+func (c LambdaConfig) recordKey(entity, fileName string) string {
+	format, _ := c.entityFormat(entity)
+	key := strings.TrimSuffix(fileName, jsonLinesExtension) + "." + format
+	if format == formatParquet {
+		return key
+	}
+	return key + c.codec.suffix
+}
+
+// writeRecordObject saves the values fill writes as one object in format. Nothing is saved if fill fails.
+func writeRecordObject(config LambdaConfig, format, fileName string, rowType reflect.Type, opts PutOptions,
+	fill func(rw recordWriter) error) (ManifestFile, error) {
+	key := strings.TrimSuffix(fileName, jsonLinesExtension) + "." + format
+
+	switch format {
+	case formatJSONLines:
+		opts.ContentType = "application/x-ndjson"
+		return streamToSink(config, fileName, opts, func(w io.Writer) error {
+			return fill(jsonLinesWriter{enc: json.NewEncoder(w)})
+		})
+	case formatCSV:
+		opts.ContentType = "text/csv"
+		return streamToSink(config, key, opts, func(w io.Writer) error {
+			cw, err := newCSVWriter(w, rowType)
+			if err != nil {
+				return err
+			}
+			if err := fill(cw); err != nil {
+				return err
+			}
+			return cw.Close()
+		})
+	default:
+		// Parquet pages are compressed inside the file, so the object itself is not
+		opts.ContentType = "application/vnd.apache.parquet"
+		return spoolToSink(config, key, opts, func(w io.Writer) error {
+			pw, err := newParquetWriter(w, rowType, config.codec)
+			if err != nil {
+				return err
+			}
+			if err := fill(pw); err != nil {
+				return err
+			}
+			return pw.Close()
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+const (
+	integrityReportPrefix = "quality/knowbe4_referential_integrity_"
+
+	// integritySamples is the most orphaned references listed in the report for each reference
+	integritySamples = 10
+
+	// allUsersGroupID is the group KnowBe4 uses for "All Users", which isn't listed with the other groups
+	allUsersGroupID = 0
+)
+
+// reference is a field of one entity holding the IDs of another
+type reference struct {
+	from  string
+	field string
+	to    string
+}
+
+// references are checked at the end of each run, for the entities saved in full
+var references = []reference{
+	{from: entityCampaigns, field: "groups.group_id", to: entityGroups},
+	{from: entitySecurityTests, field: "campaign_id", to: entityCampaigns},
+	{from: entitySecurityTests, field: "groups.group_id", to: entityGroups},
+	{from: entityRecipients, field: "user.id", to: entityUsers},
+	{from: entityUsers, field: "groups", to: entityGroups},
+}
+
+// referenceID is one reference from the record with ID id to another entity's ID ref
+type referenceID struct {
+	id  int
+	ref int
+}
+
+// OrphanedReferences sums up the IDs in one field that don't resolve to the entity they refer to
+type OrphanedReferences struct {
+	From        string           `json:"from"`
+	Field       string           `json:"field"`
+	To          string           `json:"to"`
+	Checked     int              `json:"checked"`
+	Orphaned    int              `json:"orphaned"`
+	OrphanedIDs int              `json:"orphaned_ids"`
+	Samples     []OrphanedSample `json:"samples"`
+}
+
+// OrphanedSample is a record with a reference that doesn't resolve
+type OrphanedSample struct {
+	ID        int `json:"id"`
+	Reference int `json:"reference"`
+}
+
+// IntegrityReport lists the references checked in a run
+type IntegrityReport struct {
+	RunID      string               `json:"run_id"`
+	References []OrphanedReferences `json:"references"`
+}
+
+// referenceCount is the number of rows holding one referenced ID, and the first of them seen
+type referenceCount struct {
+	rows  int
+	first int
+}
+
+// integrityCheck collects the IDs of the entities saved in a run and the references between them. Only
+// the IDs of referenced entities are kept, and each referenced ID is kept once.
+type integrityCheck struct {
+	mutex sync.Mutex
+	saved map[string]bool
+	ids   map[string]map[int]struct{}
+	refs  map[reference]map[int]referenceCount
+}
+
+func newIntegrityCheck() *integrityCheck {
+	return &integrityCheck{
+		saved: map[string]bool{},
+		ids:   map[string]map[int]struct{}{},
+		refs:  map[reference]map[int]referenceCount{},
+	}
+}
+
+// isReferenced reports whether any reference refers to the IDs of entity
+func isReferenced(entity string) bool {
+	for _, r := range references {
+		if r.to == entity {
+			return true
+		}
+	}
+	return false
+}
+
+// addIDs records that an entity was saved, with the IDs of its records if other entities refer to them
+func (c *integrityCheck) addIDs(entity string, ids ...int) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.saved[entity] = true
+	if !isReferenced(entity) {
+		return
+	}
+	if c.ids[entity] == nil {
+		c.ids[entity] = map[int]struct{}{}
+	}
+	for _, id := range ids {
+		c.ids[entity][id] = struct{}{}
+	}
+}
+
+// addReferences records the IDs held in a field of an entity's saved records
+func (c *integrityCheck) addReferences(from, field string, refs ...referenceID) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, r := range references {
+		if r.from != from || r.field != field {
+			continue
+		}
+		if c.refs[r] == nil {
+			c.refs[r] = map[int]referenceCount{}
+		}
+		for _, ref := range refs {
+			count, ok := c.refs[r][ref.ref]
+			if !ok {
+				count.first = ref.id
+			}
+			count.rows++
+			c.refs[r][ref.ref] = count
+		}
+		return
+	}
+}
+
+// report checks each reference whose entities were both saved in full
+func (c *integrityCheck) report(config LambdaConfig) IntegrityReport {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	report := IntegrityReport{RunID: config.runID, References: []OrphanedReferences{}}
+	for _, r := range references {
+		if !c.saved[r.to] || !c.saved[r.from] || config.EntityOptions[r.to].MaxPages > 0 {
+			continue
+		}
+
+		ids := c.ids[r.to]
+		result := OrphanedReferences{From: r.from, Field: r.field, To: r.to, Samples: []OrphanedSample{}}
+		orphans := map[int]int{}
+		for ref, count := range c.refs[r] {
+			if r.to == entityGroups && ref == allUsersGroupID {
+				continue
+			}
+			result.Checked += count.rows
+			if _, ok := ids[ref]; ok {
+				continue
+			}
+			result.Orphaned += count.rows
+			orphans[ref] = count.first
+		}
+		result.OrphanedIDs = len(orphans)
+
+		missing := make([]int, 0, len(orphans))
+		for ref := range orphans {
+			missing = append(missing, ref)
+		}
+		sort.Ints(missing)
+		if len(missing) > integritySamples {
+			missing = missing[:integritySamples]
+		}
+		for _, ref := range missing {
+			result.Samples = append(result.Samples, OrphanedSample{ID: orphans[ref], Reference: ref})
+		}
+		report.References = append(report.References, result)
+	}
+	return report
+}
+
+// finish logs the references that don't resolve and saves the integrity report, without failing the run
+func (c *integrityCheck) finish(config LambdaConfig, runErr *RunError) {
+	if c == nil {
+		return
+	}
+
+	report := c.report(config)
+	if len(report.References) == 0 {
+		return
+	}
+	for _, r := range report.References {
+		if r.Orphaned > 0 {
+			log.Printf("warning: %d of %d references from %s.%s to %s don't resolve, to %d distinct IDs such as %d",
+				r.Orphaned, r.Checked, r.From, r.Field, r.To, r.OrphanedIDs, r.Samples[0].Reference)
+		}
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = putObject(config, "referential_integrity", integrityReportPrefix+config.runID+".json", b,
+			PutOptions{ContentType: "application/json"})
+	}
+	if err != nil {
+		runErr.add("referential_integrity",
+			fmt.Errorf("error saving referential integrity report ... %s", err))
+	}
+}
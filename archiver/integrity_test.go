@@ -0,0 +1,110 @@
+package archiver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_integrityCheck_nil(t *testing.T) {
+	var c *integrityCheck
+	c.addIDs(entityUsers, 1)
+	c.addReferences(entityRecipients, "user.id", referenceID{id: 1, ref: 2})
+	runErr := &RunError{}
+	c.finish(LambdaConfig{}, runErr)
+	require.Empty(t, runErr.Errors)
+}
+
+func Test_integrityCheck_report(t *testing.T) {
+	assert := require.New(t)
+
+	c := newIntegrityCheck()
+	c.addIDs(entityGroups, 1, 2)
+	c.addIDs(entityUsers, 10, 11, 12)
+	c.addReferences(entityUsers, "groups", referenceID{id: 10, ref: 1}, referenceID{id: 10, ref: 3},
+		referenceID{id: 11, ref: 3}, referenceID{id: 12, ref: allUsersGroupID})
+	for i := 0; i < integritySamples+5; i++ {
+		c.addReferences(entityUsers, "groups", referenceID{id: 12, ref: 100 + i})
+	}
+
+	// Recipients weren't saved, so their references to users can't be checked
+	c.addReferences(entityRecipients, "user.id", referenceID{id: 1, ref: 99})
+
+	report := c.report(LambdaConfig{runID: "run1"})
+	assert.Len(report.References, 1)
+	got := report.References[0]
+	assert.Equal(entityUsers, got.From)
+	assert.Equal("groups", got.Field)
+	assert.Equal(entityGroups, got.To)
+	assert.Equal(3+integritySamples+5, got.Checked)
+	assert.Equal(2+integritySamples+5, got.Orphaned)
+	assert.Equal(1+integritySamples+5, got.OrphanedIDs)
+	assert.Len(got.Samples, integritySamples)
+	assert.Equal(OrphanedSample{ID: 10, Reference: 3}, got.Samples[0])
+	assert.Equal(OrphanedSample{ID: 12, Reference: 100}, got.Samples[1])
+
+	// Each referenced ID is kept once, and nothing refers to recipients, so their IDs aren't kept
+	c.addIDs(entityRecipients, 1, 2, 3)
+	c.addReferences(entityRecipients, "user.id", referenceID{id: 2, ref: 10}, referenceID{id: 3, ref: 99})
+	assert.Empty(c.ids[entityRecipients])
+	assert.Len(c.refs[references[3]], 2)
+	assert.Equal(referenceCount{rows: 2, first: 1}, c.refs[references[3]][99])
+	report = c.report(LambdaConfig{runID: "run1"})
+	assert.Len(report.References, 2)
+	assert.Equal(OrphanedReferences{From: entityRecipients, Field: "user.id", To: entityUsers, Checked: 3,
+		Orphaned: 2, OrphanedIDs: 1, Samples: []OrphanedSample{{ID: 1, Reference: 99}}}, report.References[0])
+
+	// Groups limited by MaxPages may be missing some, so references to them aren't checked
+	config := LambdaConfig{EntityOptions: map[string]EntityOptions{entityGroups: {MaxPages: 1}}}
+	assert.Len(c.report(config).References, 1)
+}
+
+func Test_archive_referentialIntegrity(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+campaignsURLPath, getTestHandler(exampleCampaigns))
+	mux.HandleFunc("/"+groupsURLPath, getTestHandler(exampleGroups))
+	mux.HandleFunc("/"+usersURLPath, getTestHandler(exampleUsers))
+	mux.HandleFunc("/"+securityTestURLPath, getTestHandler("["+exampleSecurityTest+"]"))
+	mux.HandleFunc("/"+fmt.Sprintf(recipientsURLPath, 16142), getTestHandler("["+exampleRecipient+"]"))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{
+		APIBaseURL: server.URL,
+		Entities: []string{entityCampaigns, entityGroups, entityUsers, entitySecurityTests,
+			entityRecipients},
+		runID:     "run1",
+		integrity: newIntegrityCheck(),
+		sink:      sink,
+	}
+	assert.NoError(archive(config), "orphaned references are only reported")
+
+	var report IntegrityReport
+	readJSON(t, sink, integrityReportPrefix+"run1.json", &report)
+	assert.Equal("run1", report.RunID)
+	assert.Len(report.References, len(references))
+
+	got := map[string]OrphanedReferences{}
+	for _, r := range report.References {
+		got[r.From+"."+r.Field] = r
+	}
+	assert.Equal(OrphanedReferences{
+		From:        entityRecipients,
+		Field:       "user.id",
+		To:          entityUsers,
+		Checked:     1,
+		Orphaned:    1,
+		OrphanedIDs: 1,
+		Samples:     []OrphanedSample{{ID: 3077742, Reference: 264215}},
+	}, got["recipients.user.id"])
+	assert.Equal([]OrphanedSample{{ID: 16142, Reference: 3423}}, got["security_tests.campaign_id"].Samples)
+	assert.Equal(2, got["security_tests.groups.group_id"].Orphaned)
+	assert.Equal([]OrphanedSample{{ID: 667542, Reference: 3264}}, got["users.groups"].Samples)
+	assert.Equal([]OrphanedSample{{ID: 242333, Reference: 1}}, got["campaigns.groups.group_id"].Samples)
+}
@@ -0,0 +1,77 @@
+package archiver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultPartitionedKeyTemplate lays out keys as Hive-style partitions, which Athena and Glue partition
+// projection can query without a crawler
+const defaultPartitionedKeyTemplate = "entity={entity}/dt={date}/part-{part}"
+
+// keyPlaceholders are the placeholders a key template may use
+var keyPlaceholders = map[string]bool{
+	"{entity}": true,
+	"{date}":   true,
+	"{year}":   true,
+	"{month}":  true,
+	"{day}":    true,
+	"{part}":   true,
+}
+
+var keyPlaceholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// keyTemplate returns the template for the keys of archived files, or "" to use the original keys
+func (c LambdaConfig) keyTemplate() string {
+	if c.KeyTemplate == "" && c.PartitionedKeys {
+		return defaultPartitionedKeyTemplate
+	}
+	return c.KeyTemplate
+}
+
+func validateKeyTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+
+	for _, p := range keyPlaceholderPattern.FindAllString(template, -1) {
+		if !keyPlaceholders[p] {
+			return fmt.Errorf("unknown placeholder %s in key template %q", p, template)
+		}
+	}
+	for _, required := range []string{"{entity}", "{part}"} {
+		if !strings.Contains(template, required) {
+			return fmt.Errorf("key template %q must include %s", template, required)
+		}
+	}
+	if strings.HasPrefix(template, "/") {
+		return fmt.Errorf("key template %q must not start with /", template)
+	}
+	return nil
+}
+
+// objectKey returns the key of one archived file of an entity, from the key template if there is one.
+// part is the ID of the item for entities saved one file per item, and 0 for the others.
+func (c LambdaConfig) objectKey(entity, originalKey string, part int) string {
+	return c.objectKeyAt(entity, originalKey, part, time.Now())
+}
+
+// objectKeyAt is objectKey with the date placeholders filled from date rather than today
+func (c LambdaConfig) objectKeyAt(entity, originalKey string, part int, date time.Time) string {
+	template := c.keyTemplate()
+	if template == "" {
+		return originalKey
+	}
+
+	r := strings.NewReplacer(
+		"{entity}", entity,
+		"{date}", date.Format("2006-01-02"),
+		"{year}", date.Format("2006"),
+		"{month}", date.Format("01"),
+		"{day}", date.Format("02"),
+		"{part}", fmt.Sprintf("%04d", part),
+	)
+	return r.Replace(template) + jsonLinesExtension
+}
@@ -0,0 +1,88 @@
+package archiver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateKeyTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{template: "", wantErr: false},
+		{template: defaultPartitionedKeyTemplate, wantErr: false},
+		{template: "knowbe4/{entity}/year={year}/month={month}/day={day}/{part}", wantErr: false},
+		{template: "entity={entity}/dt={date}/data", wantErr: true},
+		{template: "dt={date}/part-{part}", wantErr: true},
+		{template: "entity={entity}/hour={hour}/part-{part}", wantErr: true},
+		{template: "/entity={entity}/part-{part}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			err := validateKeyTemplate(tt.template)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_LambdaConfig_objectKey(t *testing.T) {
+	assert := require.New(t)
+
+	today := time.Now().Format("2006-01-02")
+
+	assert.Equal(groupsFilename, LambdaConfig{}.objectKey(entityGroups, groupsFilename, 0))
+
+	config := LambdaConfig{PartitionedKeys: true}
+	assert.Equal("entity=groups/dt="+today+"/part-0000.jsonl", config.objectKey(entityGroups, groupsFilename, 0))
+	assert.Equal("entity=recipients/dt="+today+"/part-14240.jsonl",
+		config.objectKey(entityRecipients, recipientsFilenamePrefix+"14240.jsonl", 14240))
+
+	// Recipients are dated by the start of their test
+	started := time.Date(2026, 9, 1, 14, 0, 0, 0, time.UTC)
+	assert.Equal("entity=recipients/dt=2026-09-01/part-14240.jsonl",
+		config.recipientsFileName(KnowBe4SecurityTest{PstID: 14240, StartedAt: &started}))
+
+	config = LambdaConfig{KeyTemplate: "{entity}/{year}/{month}/{day}/{part}"}
+	assert.Equal("users/"+time.Now().Format("2006/01/02")+"/0000.jsonl",
+		config.objectKey(entityUsers, usersFilenamePrefix+today+".jsonl", 0))
+}
+
+func Test_archive_partitionedKeys(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+groupsURLPath, getTestHandler(exampleGroups))
+	mux.HandleFunc("/"+trainingEnrollmentsURLPath, getTestHandler(exampleTrainingEnrollments))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{
+		APIBaseURL:      server.URL,
+		Entities:        []string{entityGroups, entityTrainingEnrollments},
+		EntityOptions:   map[string]EntityOptions{entityTrainingEnrollments: {Format: formatCSV}},
+		PartitionedKeys: true,
+		sink:            sink,
+	}
+	assert.NoError(archive(config))
+
+	objects, err := sink.List("entity=")
+	assert.NoError(err)
+	assert.Len(objects, 2)
+
+	dt := "/dt=" + time.Now().Format("2006-01-02") + "/"
+	assert.Equal("entity=groups"+dt+"part-0000.jsonl", objects[0].Key)
+	assert.Equal("entity=training_enrollments"+dt+"part-0000.csv", objects[1].Key)
+
+	_, err = sink.Stat(groupsFilename)
+	assert.Error(err, "the original key should not be written")
+}
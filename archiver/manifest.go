@@ -0,0 +1,254 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	manifestPrefix    = "manifests/"
+	latestManifestKey = manifestPrefix + "latest.json"
+
+	// latestEntityManifestPrefix is where the pointer for each entity is saved, as <entity>.json
+	latestEntityManifestPrefix = manifestPrefix + "latest/"
+)
+
+// manifestSecretFields are the LambdaConfig fields left out of the config saved in a manifest
+var manifestSecretFields = []string{"APIAuthToken", "PseudonymKey"}
+
+// Manifest describes what one run wrote. It is saved last, so its presence means the run has finished.
+type Manifest struct {
+	RunID      string                     `json:"run_id"`
+	StartedAt  time.Time                  `json:"started_at"`
+	FinishedAt time.Time                  `json:"finished_at"`
+	Succeeded  bool                       `json:"succeeded"`
+	Config     map[string]interface{}     `json:"config"`
+	Entities   map[string]*ManifestEntity `json:"entities"`
+	Files      []ManifestFile             `json:"files"`
+}
+
+// ManifestEntity sums up one entity selected for the run
+type ManifestEntity struct {
+	Pages   int   `json:"pages"`
+	Files   int   `json:"files"`
+	Records int64 `json:"records"`
+
+	// Unchanged counts the files that already held the same content, so were not uploaded again
+	Unchanged int    `json:"unchanged"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ManifestFile is one object written in the run. Unchanged is set if it wasn't uploaded again because
+// it held the same content.
+type ManifestFile struct {
+	Key       string `json:"key"`
+	Entity    string `json:"entity"`
+	Records   int64  `json:"records"`
+	Bytes     int64  `json:"bytes"`
+	SHA256    string `json:"sha256"`
+	Pages     int    `json:"pages"`
+	Unchanged bool   `json:"unchanged,omitempty"`
+
+	RedactedCopy bool `json:"redacted_copy,omitempty"`
+}
+
+// LatestManifest is saved at latestManifestKey, pointing to the manifest of the last run to finish, and
+// for each entity under latestEntityManifestPrefix, pointing to the last run that included the entity
+type LatestManifest struct {
+	Entity      string    `json:"entity,omitempty"`
+	RunID       string    `json:"run_id"`
+	ManifestKey string    `json:"manifest_key"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Succeeded   bool      `json:"succeeded"`
+}
+
+// runManifest collects the manifest of a run as it goes
+type runManifest struct {
+	mutex    sync.Mutex
+	manifest Manifest
+	pages    map[string]int
+}
+
+func newRunManifest(runID string, startedAt time.Time) *runManifest {
+	return &runManifest{
+		manifest: Manifest{RunID: runID, StartedAt: startedAt, Entities: map[string]*ManifestEntity{}},
+		pages:    map[string]int{},
+	}
+}
+
+// countPage records that an API page was fetched for an entity
+func (m *runManifest) countPage(entity string) {
+	if m == nil || entity == "" {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pages[entity]++
+}
+
+// entityPages returns the number of API pages fetched for an entity so far
+func (m *runManifest) entityPages(entity string) int {
+	if m == nil {
+		return 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.pages[entity]
+}
+
+func (m *runManifest) addFile(f ManifestFile) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.manifest.Files = append(m.manifest.Files, f)
+}
+
+// finish saves the manifest of the run, then points latestManifestKey and the pointer of each selected
+// entity at it. Runs can select different entities, so the pointer of an entity is only replaced by a
+// run that included it.
+func (m *runManifest) finish(config LambdaConfig, selected map[string]bool, runErr *RunError) error {
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	manifest := m.manifest
+	manifest.FinishedAt = time.Now().UTC()
+	manifest.Succeeded = len(runErr.Errors) == 0
+	manifest.Config = manifestConfig(config)
+	manifest.Files = append([]ManifestFile{}, m.manifest.Files...)
+	manifest.Entities = map[string]*ManifestEntity{}
+	for entity := range selected {
+		manifest.Entities[entity] = &ManifestEntity{Pages: m.pages[entity]}
+	}
+	m.mutex.Unlock()
+
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Key < manifest.Files[j].Key })
+	unchanged := 0
+	for _, f := range manifest.Files {
+		if f.Unchanged {
+			unchanged++
+		}
+		if e, ok := manifest.Entities[f.Entity]; ok && !f.RedactedCopy {
+			e.Files++
+			e.Records += f.Records
+			if f.Unchanged {
+				e.Unchanged++
+			}
+		}
+	}
+	log.Printf("wrote %d files, %d of them unchanged and not uploaded again", len(manifest.Files), unchanged)
+
+	for _, entityErr := range runErr.Errors {
+		if e, ok := manifest.Entities[entityErr.Entity]; ok {
+			e.Error = entityErr.Err.Error()
+		}
+	}
+
+	key := manifestPrefix + manifest.RunID + ".json"
+	if err := putJSON(config, key, manifest); err != nil {
+		return fmt.Errorf("error saving run manifest ... %s", err)
+	}
+
+	entities := make([]string, 0, len(manifest.Entities))
+	for entity := range manifest.Entities {
+		entities = append(entities, entity)
+	}
+	sort.Strings(entities)
+	for _, entity := range entities {
+		latest := LatestManifest{
+			Entity:      entity,
+			RunID:       manifest.RunID,
+			ManifestKey: key,
+			FinishedAt:  manifest.FinishedAt,
+			Succeeded:   manifest.Entities[entity].Error == "",
+		}
+		if err := putJSON(config, latestEntityManifestPrefix+entity+".json", latest); err != nil {
+			return fmt.Errorf("error saving latest manifest pointer for %s ... %s", entity, err)
+		}
+	}
+
+	latest := LatestManifest{
+		RunID:       manifest.RunID,
+		ManifestKey: key,
+		FinishedAt:  manifest.FinishedAt,
+		Succeeded:   manifest.Succeeded,
+	}
+	if err := putJSON(config, latestManifestKey, latest); err != nil {
+		return fmt.Errorf("error saving latest manifest pointer ... %s", err)
+	}
+	return nil
+}
+
+// manifestConfig returns the settings of the run, without secrets
+func manifestConfig(config LambdaConfig) map[string]interface{} {
+	settings := map[string]interface{}{}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return settings
+	}
+	_ = json.Unmarshal(b, &settings)
+	for _, field := range manifestSecretFields {
+		delete(settings, field)
+	}
+	return settings
+}
+
+func putJSON(config LambdaConfig, key string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	opts := PutOptions{ContentType: "application/json", Metadata: config.objectMetadata("")}
+	return config.sink.Put(key, bytes.NewReader(b), opts)
+}
+
+// putObject saves body to the sink, with the metadata of entity, and adds it to the run manifest
+func putObject(config LambdaConfig, entity, key string, body []byte, opts PutOptions) error {
+	opts.Metadata = config.objectMetadata(entity)
+	if err := config.sink.Put(key, bytes.NewReader(body), opts); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	config.manifest.addFile(ManifestFile{
+		Key:    key,
+		Entity: entity,
+		Bytes:  int64(len(body)),
+		SHA256: hex.EncodeToString(sum[:]),
+		Pages:  1,
+	})
+	return nil
+}
+
+// hashingWriter counts and hashes the bytes written through it
+type hashingWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	n    int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, hash: sha256.New()}
+}
+
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.hash.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+func (h *hashingWriter) sum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
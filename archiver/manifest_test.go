@@ -0,0 +1,120 @@
+package archiver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readJSON(t *testing.T, sink Sink, key string, v interface{}) []byte {
+	r, err := sink.Get(key)
+	require.NoError(t, err)
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	if v != nil {
+		require.NoError(t, json.Unmarshal(b, v))
+	}
+	return b
+}
+
+func Test_archive_manifest(t *testing.T) {
+	assert := require.New(t)
+
+	// Campaigns are not served, so they fail
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+groupsURLPath, getTestHandler(exampleGroups))
+	mux.HandleFunc("/"+usersURLPath, getTestHandler(exampleUsers))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	started := time.Date(2026, 10, 16, 6, 10, 0, 0, time.UTC)
+	config := LambdaConfig{
+		APIBaseURL:   server.URL,
+		APIAuthToken: "secret",
+		Entities:     []string{entityCampaigns, entityGroups, entityUsers},
+		RawResponses: true,
+		runID:        "run1",
+		manifest:     newRunManifest("run1", started),
+		sink:         sink,
+	}
+	assert.Error(archive(config))
+
+	var latest LatestManifest
+	readJSON(t, sink, latestManifestKey, &latest)
+	assert.Equal("run1", latest.RunID)
+	assert.Equal("manifests/run1.json", latest.ManifestKey)
+	assert.False(latest.Succeeded)
+
+	// Each entity has its own pointer, which only says whether that entity succeeded
+	var latestGroups, latestCampaigns LatestManifest
+	readJSON(t, sink, latestEntityManifestPrefix+entityGroups+".json", &latestGroups)
+	assert.Equal(LatestManifest{Entity: entityGroups, RunID: "run1", ManifestKey: "manifests/run1.json",
+		FinishedAt: latest.FinishedAt, Succeeded: true}, latestGroups)
+	readJSON(t, sink, latestEntityManifestPrefix+entityCampaigns+".json", &latestCampaigns)
+	assert.False(latestCampaigns.Succeeded)
+
+	// A later run of other entities leaves their pointers alone
+	config.Entities = []string{entityUsers}
+	config.runID = "run2"
+	config.manifest = newRunManifest("run2", started)
+	assert.NoError(archive(config))
+	readJSON(t, sink, latestEntityManifestPrefix+entityGroups+".json", &latestGroups)
+	assert.Equal("run1", latestGroups.RunID)
+	var latestUsers LatestManifest
+	readJSON(t, sink, latestEntityManifestPrefix+entityUsers+".json", &latestUsers)
+	assert.Equal("run2", latestUsers.RunID)
+	readJSON(t, sink, latestManifestKey, &latest)
+	assert.Equal("run2", latest.RunID)
+
+	var manifest Manifest
+	b := readJSON(t, sink, "manifests/run1.json", &manifest)
+	assert.NotContains(string(b), "secret")
+	assert.Equal(started, manifest.StartedAt)
+	assert.False(manifest.FinishedAt.Before(started))
+	assert.Equal([]interface{}{entityCampaigns, entityGroups, entityUsers}, manifest.Config["Entities"])
+
+	assert.Len(manifest.Entities, 3)
+	assert.Contains(manifest.Entities[entityCampaigns].Error, "error saving campaigns")
+	assert.Equal(&ManifestEntity{Pages: 1, Files: 2, Records: 2}, manifest.Entities[entityGroups])
+	assert.Equal(&ManifestEntity{Pages: 1, Files: 2, Records: 1}, manifest.Entities[entityUsers])
+
+	// Files are sorted by key, so the raw responses come between groups and users
+	var keys []string
+	for _, f := range manifest.Files {
+		keys = append(keys, f.Key)
+	}
+	today := time.Now().Format("2006-01-02")
+	assert.Equal([]string{groupsFilename, "raw/groups/run1/page-1.json", "raw/users/run1/page-1.json",
+		usersFilenamePrefix + today + ".jsonl"}, keys)
+
+	groups := manifest.Files[0]
+	data := readJSON(t, sink, groupsFilename, nil)
+	sum := sha256.Sum256(data)
+	assert.Equal(ManifestFile{
+		Key:     groupsFilename,
+		Entity:  entityGroups,
+		Records: 2,
+		Bytes:   int64(len(data)),
+		SHA256:  hex.EncodeToString(sum[:]),
+		Pages:   1,
+	}, groups)
+	assert.Equal(int64(0), manifest.Files[1].Records)
+}
+
+func Test_runManifest_nil(t *testing.T) {
+	var m *runManifest
+	m.countPage(entityUsers)
+	m.addFile(ManifestFile{Key: "a"})
+	require.Zero(t, m.entityPages(entityUsers))
+	require.NoError(t, m.finish(LambdaConfig{}, nil, &RunError{}))
+}
@@ -0,0 +1,175 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// PageOptions describes a paged KnowBe4 list endpoint
+type PageOptions struct {
+	// Entity is the name of the entity the endpoint returns, used to report schema drift
+	Entity string
+
+	// Path is the URL path relative to the API base URL, e.g. "v1/users"
+	Path string
+
+	// Params are extra query parameters. "page" and "per_page" are added by Paginate.
+	Params map[string]string
+
+	// PageSize overrides the number of items requested per page (default countPerPage)
+	PageSize int
+
+	// MaxPages stops paging after this many pages. Zero means no limit.
+	MaxPages int
+
+	// RawPrefix is where the exact body of each page is saved, as RawPrefix + "page-N.json". Nothing is
+	// saved if it is empty.
+	RawPrefix string
+}
+
+// Page is one page of results from a list endpoint
+type Page[T any] struct {
+	Number int
+	Items  []T
+
+	// Body is the raw response body the items were decoded from
+	Body []byte
+}
+
+// PageError identifies the page that could not be fetched or decoded
+type PageError struct {
+	Path string
+	Page int
+	Err  error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("error fetching %s page %v ... %s", e.Path, e.Page, e.Err)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+// Paginate passes each page of a list endpoint to fn as it arrives, until a short page, opts.MaxPages
+// pages, or an error from fn
+func Paginate[T any](config LambdaConfig, opts PageOptions, fn func(Page[T]) error) error {
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = countPerPage
+	}
+
+	for i := 1; opts.MaxPages == 0 || i <= opts.MaxPages; i++ {
+		page, err := getPage[T](config, opts, pageSize, i)
+		if err != nil {
+			return &PageError{Path: opts.Path, Page: i, Err: err}
+		}
+
+		if err := saveRawPage(config, opts, i, page.Body); err != nil {
+			return err
+		}
+
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		if len(page.Items) < pageSize {
+			break
+		}
+	}
+
+	return nil
+}
+
+func getPage[T any](config LambdaConfig, opts PageOptions, pageSize, pageNum int) (Page[T], error) {
+	queryParams := map[string]string{
+		"per_page": strconv.Itoa(pageSize),
+		"page":     strconv.Itoa(pageNum),
+	}
+	for key, val := range opts.Params {
+		queryParams[key] = val
+	}
+
+	// Make http call
+	resp, err := callAPI(opts.Path, config, queryParams)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Page[T]{}, fmt.Errorf("error reading response body: %s", err)
+	}
+
+	var items []T
+
+	if err := json.Unmarshal(bodyBytes, &items); err != nil {
+		return Page[T]{}, fmt.Errorf("error decoding response json: %s", err)
+	}
+
+	config.drift.check(opts.Entity, reflect.TypeOf(items), bodyBytes)
+	config.manifest.countPage(opts.Entity)
+
+	return Page[T]{Number: pageNum, Items: items, Body: bodyBytes}, nil
+}
+
+// getAll collects the items of every page of a list endpoint. It is only for lists that are small or
+// needed whole; others should be written out or reduced page by page with Paginate.
+func getAll[T any](config LambdaConfig, opts PageOptions) ([]T, error) {
+	var allItems []T
+
+	err := Paginate(config, opts, func(p Page[T]) error {
+		allItems = append(allItems, p.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return allItems, nil
+}
+
+// getObject gets a single JSON value from an endpoint that isn't paged
+func getObject[T any](config LambdaConfig, opts PageOptions) (T, error) {
+	var obj T
+
+	resp, err := callAPI(opts.Path, config, opts.Params)
+	if err != nil {
+		return obj, err
+	}
+
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return obj, fmt.Errorf("error reading response body: %s", err)
+	}
+
+	if err := saveRawPage(config, opts, 1, bodyBytes); err != nil {
+		return obj, err
+	}
+
+	if err := json.Unmarshal(bodyBytes, &obj); err != nil {
+		return obj, fmt.Errorf("error decoding response json for %s: %s", opts.Path, err)
+	}
+
+	config.drift.check(opts.Entity, reflect.TypeOf(obj), bodyBytes)
+	config.manifest.countPage(opts.Entity)
+	return obj, nil
+}
+
+// saveRawPage saves the exact body of one API response, unless opts.RawPrefix is empty
+func saveRawPage(config LambdaConfig, opts PageOptions, pageNum int, body []byte) error {
+	if opts.RawPrefix == "" {
+		return nil
+	}
+
+	key := fmt.Sprintf("%spage-%d.json", opts.RawPrefix, pageNum)
+	if err := putObject(config, opts.Entity, key, body, PutOptions{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("error saving raw response ... %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// getPagedTestServer serves totalItems ints, honoring the page and per_page query parameters.
+// Requests for failPage get a 400 response.
+func getPagedTestServer(t *testing.T, totalItems, failPage int) (string, *[]string) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if page == failPage {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var items []string
+		for i := (page-1)*perPage + 1; i <= page*perPage && i <= totalItems; i++ {
+			items = append(items, strconv.Itoa(i))
+		}
+		_, _ = fmt.Fprintf(w, "[%s]", strings.Join(items, ","))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &queries
+}
+
+func Test_Paginate(t *testing.T) {
+	tests := []struct {
+		name      string
+		total     int
+		opts      PageOptions
+		wantPages []int
+		wantItems int
+	}{
+		{
+			name:      "single short page",
+			total:     3,
+			opts:      PageOptions{PageSize: 5},
+			wantPages: []int{1},
+			wantItems: 3,
+		},
+		{
+			name:      "exact multiple needs an empty last page",
+			total:     10,
+			opts:      PageOptions{PageSize: 5},
+			wantPages: []int{1, 2, 3},
+			wantItems: 10,
+		},
+		{
+			name:      "max pages",
+			total:     100,
+			opts:      PageOptions{PageSize: 5, MaxPages: 2},
+			wantPages: []int{1, 2},
+			wantItems: 10,
+		},
+		{
+			name:      "default page size",
+			total:     countPerPage + 1,
+			opts:      PageOptions{},
+			wantPages: []int{1, 2},
+			wantItems: countPerPage + 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			testURL, _ := getPagedTestServer(t, tt.total, 0)
+
+			var gotPages []int
+			gotItems := 0
+			err := Paginate(LambdaConfig{APIBaseURL: testURL}, tt.opts, func(p Page[int]) error {
+				gotPages = append(gotPages, p.Number)
+				gotItems += len(p.Items)
+				return nil
+			})
+			assert.NoError(err)
+			assert.Equal(tt.wantPages, gotPages)
+			assert.Equal(tt.wantItems, gotItems)
+		})
+	}
+}
+
+func Test_Paginate_params(t *testing.T) {
+	assert := require.New(t)
+	testURL, queries := getPagedTestServer(t, 1, 0)
+
+	opts := PageOptions{Params: map[string]string{"status": "active"}, PageSize: 5}
+	err := Paginate(LambdaConfig{APIBaseURL: testURL}, opts, func(p Page[int]) error { return nil })
+	assert.NoError(err)
+	assert.Equal([]string{"page=1&per_page=5&status=active"}, *queries)
+}
+
+func Test_Paginate_pageError(t *testing.T) {
+	assert := require.New(t)
+	testURL, _ := getPagedTestServer(t, 100, 3)
+
+	err := Paginate(LambdaConfig{APIBaseURL: testURL}, PageOptions{Path: "v1/users", PageSize: 5},
+		func(p Page[int]) error { return nil })
+
+	var pageErr *PageError
+	assert.True(errors.As(err, &pageErr))
+	assert.Equal(3, pageErr.Page)
+	assert.Equal("v1/users", pageErr.Path)
+}
+
+func Test_Paginate_callbackError(t *testing.T) {
+	assert := require.New(t)
+	testURL, queries := getPagedTestServer(t, 100, 0)
+
+	stop := errors.New("stop")
+	err := Paginate(LambdaConfig{APIBaseURL: testURL}, PageOptions{PageSize: 5},
+		func(p Page[int]) error { return stop })
+	assert.Equal(stop, err)
+	assert.Len(*queries, 1)
+}
+
+func Test_Paginate_rawPages(t *testing.T) {
+	assert := require.New(t)
+	testURL, _ := getPagedTestServer(t, 7, 0)
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: testURL, RawResponses: true, runID: "run1", sink: sink}
+	opts := config.pageOptions(entityUsers, usersURLPath)
+	opts.PageSize = 5
+
+	items, err := getAll[int](config, opts)
+	assert.NoError(err)
+	assert.Len(items, 7)
+
+	objects, err := sink.List("raw/")
+	assert.NoError(err)
+	assert.Len(objects, 2)
+	assert.Equal("raw/users/run1/page-1.json", objects[0].Key)
+	assert.Equal("raw/users/run1/page-2.json", objects[1].Key)
+
+	r, err := sink.Get(objects[1].Key)
+	assert.NoError(err)
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	assert.NoError(err)
+	assert.Equal("[6,7]", string(body))
+
+	assert.Equal("raw/recipients/run1/123/", config.rawPrefix(entityRecipients, 123))
+}
+
+func Test_Paginate_noRawPages(t *testing.T) {
+	assert := require.New(t)
+	testURL, _ := getPagedTestServer(t, 3, 0)
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: testURL, runID: "run1", sink: sink}
+
+	_, err := getAll[int](config, config.pageOptions(entityUsers, usersURLPath))
+	assert.NoError(err)
+
+	objects, err := sink.List("raw/")
+	assert.NoError(err)
+	assert.Empty(objects)
+}
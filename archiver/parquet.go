@@ -0,0 +1,195 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/schema"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Parquet files are written with github.com/xitongsys/parquet-go. Each struct field becomes a column
+// named after its JSON key: nested structs become Parquet groups, slices become LISTs, pointers become
+// OPTIONAL fields and times become millisecond UTC timestamps. Pages are compressed with the configured
+// codec.
+
+const (
+	parquetCreatedBy = "knowbe4-data-archiver"
+
+	// Rows are flushed as a row group once their pages reach this size, which bounds the memory a file
+	// takes to write
+	parquetRowGroupSize = 4 << 20
+)
+
+// parquetWriter writes values of one struct type to a Parquet file. Close must be called to write the
+// footer. It does not close the underlying writer.
+type parquetWriter struct {
+	pw      *writer.JSONWriter
+	rowType reflect.Type
+}
+
+func newParquetWriter(w io.Writer, rowType reflect.Type, c codec) (*parquetWriter, error) {
+	root, err := newParquetSchema(rowType)
+	if err != nil {
+		return nil, err
+	}
+	s, err := json.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := writer.NewJSONWriterFromWriter(string(s), w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	pw.CompressionType = c.parquetCodec
+	createdBy := parquetCreatedBy
+	pw.Footer.CreatedBy = &createdBy
+	return &parquetWriter{pw: pw, rowType: rowType}, nil
+}
+
+// Write adds one row, which must be of the writer's row type or a pointer to it
+func (p *parquetWriter) Write(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Type() != p.rowType {
+		return fmt.Errorf("can't write %s to a Parquet file of %s", rv.Type(), p.rowType)
+	}
+
+	b, err := json.Marshal(parquetValue(rv))
+	if err != nil {
+		return err
+	}
+	return p.pw.Write(b)
+}
+
+// Close writes the last row group and the footer
+func (p *parquetWriter) Close() error {
+	return p.pw.WriteStop()
+}
+
+// newParquetSchema builds the schema of a Go struct type, in the JSON form parquet-go reads
+func newParquetSchema(t reflect.Type) (*schema.JSONSchemaItemType, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't write %s as Parquet, rows must be structs", t)
+	}
+
+	fields, err := parquetStructFields(t)
+	if err != nil {
+		return nil, err
+	}
+	return &schema.JSONSchemaItemType{Tag: "name=schema, repetitiontype=REQUIRED", Fields: fields}, nil
+}
+
+func parquetStructFields(t reflect.Type) ([]*schema.JSONSchemaItemType, error) {
+	var fields []*schema.JSONSchemaItemType
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := parquetFieldName(f)
+		if name == "" {
+			continue
+		}
+
+		field, err := newParquetField(name, f.Type)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// parquetFieldName returns the JSON name of a struct field, or "" if it isn't saved
+func parquetFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" || !f.IsExported() {
+		return ""
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+func newParquetField(name string, t reflect.Type) (*schema.JSONSchemaItemType, error) {
+	repetition := "REQUIRED"
+	if t.Kind() == reflect.Ptr {
+		repetition = "OPTIONAL"
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice {
+		repetition = "OPTIONAL"
+	}
+	tag := "name=" + name + ", repetitiontype=" + repetition
+
+	switch {
+	case t == timeType:
+		tag += ", type=INT64, convertedtype=TIMESTAMP_MILLIS, logicaltype=TIMESTAMP, " +
+			"logicaltype.isadjustedtoutc=true, logicaltype.unit=MILLIS"
+	case t.Kind() == reflect.String:
+		tag += ", type=BYTE_ARRAY, convertedtype=UTF8"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		tag += ", type=INT64"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		tag += ", type=DOUBLE"
+	case t.Kind() == reflect.Bool:
+		tag += ", type=BOOLEAN"
+	case t.Kind() == reflect.Struct:
+		fields, err := parquetStructFields(t)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("can't write %s as Parquet, struct %s has no fields", name, t)
+		}
+		return &schema.JSONSchemaItemType{Tag: tag, Fields: fields}, nil
+	case t.Kind() == reflect.Slice:
+		element, err := newParquetField("element", t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		tag += ", type=LIST"
+		return &schema.JSONSchemaItemType{Tag: tag, Fields: []*schema.JSONSchemaItemType{element}}, nil
+	default:
+		return nil, fmt.Errorf("can't write %s as Parquet, unsupported type %s", name, t)
+	}
+	return &schema.JSONSchemaItemType{Tag: tag}, nil
+}
+
+// parquetValue returns v as the JSON parquet-go expects for the schema of its type, which differs from
+// the archived JSON in holding times as milliseconds
+func parquetValue(v reflect.Value) interface{} {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Slice) && v.IsNil() {
+		return nil
+	}
+
+	switch {
+	case v.Kind() == reflect.Ptr:
+		return parquetValue(v.Elem())
+	case v.Type() == timeType:
+		return v.Interface().(time.Time).UnixMilli()
+	case v.Kind() == reflect.Struct:
+		row := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			if name := parquetFieldName(v.Type().Field(i)); name != "" {
+				row[name] = parquetValue(v.Field(i))
+			}
+		}
+		return row
+	case v.Kind() == reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = parquetValue(v.Index(i))
+		}
+		return list
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,221 @@
+package archiver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+type parquetTestRow struct {
+	ID      int        `json:"id"`
+	Name    string     `json:"name"`
+	Score   float64    `json:"score"`
+	Active  bool       `json:"active"`
+	Started *time.Time `json:"started"`
+	Owner   struct {
+		Email string  `json:"email"`
+		GUID  *string `json:"guid"`
+	} `json:"owner"`
+	Groups []GroupSummary `json:"groups"`
+	Tags   []int          `json:"tags"`
+}
+
+type parquetTestColumn struct {
+	values     []interface{}
+	reps, defs []int32
+}
+
+// readParquetFile reads a Parquet file with parquet-go's reader, returning its metadata and, for each
+// column path, the values and levels of all of its rows
+func readParquetFile(t *testing.T, data []byte) (*parquet.FileMetaData, map[string]*parquetTestColumn) {
+	f, err := buffer.NewBufferFile(data)
+	require.NoError(t, err)
+	pr, err := reader.NewParquetColumnReader(f, 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+
+	// The reader renames the schema to parquet-go's Go names, so it's named back as it is in the file
+	for i, info := range pr.SchemaHandler.Infos {
+		pr.Footer.Schema[i].Name = info.ExName
+	}
+
+	columns := map[string]*parquetTestColumn{}
+	for i, inPath := range pr.SchemaHandler.ValueColumns {
+		values, reps, defs, err := pr.ReadColumnByIndex(int64(i), pr.GetNumRows())
+		require.NoError(t, err)
+		path := common.StrToPath(pr.SchemaHandler.InPathToExPath[inPath])[1:]
+		columns[strings.Join(path, ".")] = &parquetTestColumn{values: values, reps: reps, defs: defs}
+	}
+	return pr.Footer, columns
+}
+
+func Test_newParquetSchema(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := newParquetSchema(reflect.TypeOf(parquetTestRow{}))
+	assert.NoError(err)
+
+	var tags []string
+	for _, f := range root.Fields {
+		tags = append(tags, f.Tag)
+	}
+	assert.Equal([]string{
+		"name=id, repetitiontype=REQUIRED, type=INT64",
+		"name=name, repetitiontype=REQUIRED, type=BYTE_ARRAY, convertedtype=UTF8",
+		"name=score, repetitiontype=REQUIRED, type=DOUBLE",
+		"name=active, repetitiontype=REQUIRED, type=BOOLEAN",
+		"name=started, repetitiontype=OPTIONAL, type=INT64, convertedtype=TIMESTAMP_MILLIS, logicaltype=TIMESTAMP, " +
+			"logicaltype.isadjustedtoutc=true, logicaltype.unit=MILLIS",
+		"name=owner, repetitiontype=REQUIRED",
+		"name=groups, repetitiontype=OPTIONAL, type=LIST",
+		"name=tags, repetitiontype=OPTIONAL, type=LIST",
+	}, tags)
+	assert.Len(root.Fields[5].Fields, 2)
+	assert.Equal("name=element, repetitiontype=REQUIRED", root.Fields[6].Fields[0].Tag)
+
+	_, err = newParquetSchema(reflect.TypeOf(map[string]int{}))
+	assert.Error(err)
+
+	_, err = newParquetSchema(reflect.TypeOf(struct{ M map[string]int }{}))
+	assert.Error(err)
+}
+
+func Test_parquetWriter(t *testing.T) {
+	started := time.Date(2020, 11, 6, 12, 30, 0, 0, time.UTC)
+	guid := "abc"
+	rows := make([]parquetTestRow, 3)
+	rows[0].ID, rows[0].Name, rows[0].Score, rows[0].Active = 1, "first", 1.5, true
+	rows[0].Started = &started
+	rows[0].Owner.Email, rows[0].Owner.GUID = "a@example.com", &guid
+	rows[0].Groups = []GroupSummary{{GroupID: 10, Name: "ten"}, {GroupID: 11, Name: "eleven"}}
+	rows[0].Tags = []int{7}
+	rows[1].ID, rows[1].Groups, rows[1].Tags = 2, []GroupSummary{}, []int{}
+	rows[2].ID, rows[2].Tags = 3, []int{8, 9}
+
+	for _, name := range []string{compressionNone, compressionGzip, compressionZstd} {
+		t.Run(name, func(t *testing.T) {
+			assert := require.New(t)
+			c, err := newCodec(name)
+			assert.NoError(err)
+
+			var buf bytes.Buffer
+			pw, err := newParquetWriter(&buf, reflect.TypeOf(parquetTestRow{}), c)
+			assert.NoError(err)
+			for _, r := range rows {
+				assert.NoError(pw.Write(r))
+			}
+			assert.Error(pw.Write(GroupSummary{}))
+			assert.NoError(pw.Close())
+
+			meta, columns := readParquetFile(t, buf.Bytes())
+			assert.Equal(int64(3), meta.NumRows)
+			assert.Equal(parquetCreatedBy, meta.GetCreatedBy())
+			assert.Equal(c.parquetCodec, meta.RowGroups[0].Columns[0].MetaData.Codec)
+
+			assert.Equal([]interface{}{int64(1), int64(2), int64(3)}, columns["id"].values)
+			assert.Equal([]interface{}{"first", "", ""}, columns["name"].values)
+			assert.Equal([]interface{}{1.5, 0.0, 0.0}, columns["score"].values)
+			assert.Equal([]interface{}{true, false, false}, columns["active"].values)
+
+			startedCol := columns["started"]
+			assert.Equal([]int32{1, 0, 0}, startedCol.defs)
+			assert.Equal([]interface{}{started.UnixMilli(), nil, nil}, startedCol.values)
+
+			assert.Equal([]interface{}{"abc", nil, nil}, columns["owner.guid"].values)
+
+			// A list with two items, an empty list and a missing list
+			groupID := columns["groups.list.element.group_id"]
+			assert.Equal([]int32{0, 1, 0, 0}, groupID.reps)
+			assert.Equal([]int32{2, 2, 1, 0}, groupID.defs)
+			assert.Equal([]interface{}{int64(10), int64(11), nil, nil}, groupID.values)
+
+			tags := columns["tags.list.element"]
+			assert.Equal([]int32{0, 0, 0, 1}, tags.reps)
+			assert.Equal([]int32{2, 1, 2, 2}, tags.defs)
+			assert.Equal([]interface{}{int64(7), nil, int64(8), int64(9)}, tags.values)
+
+			// Converted types are recorded for strings, lists and timestamps, which are in UTC
+			elements := map[string]*parquet.SchemaElement{}
+			for _, el := range meta.Schema {
+				elements[el.Name] = el
+			}
+			assert.Equal(parquet.ConvertedType_UTF8, elements["name"].GetConvertedType())
+			assert.Equal(parquet.ConvertedType_LIST, elements["groups"].GetConvertedType())
+			assert.Equal(parquet.ConvertedType_TIMESTAMP_MILLIS, elements["started"].GetConvertedType())
+			assert.True(elements["started"].GetLogicalType().GetTIMESTAMP().IsAdjustedToUTC)
+		})
+	}
+}
+
+func Test_parquetWriter_rowGroups(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	pw, err := newParquetWriter(&buf, reflect.TypeOf(GroupSummary{}), codec{})
+	assert.NoError(err)
+	rows := 40000
+	for i := 0; i < rows; i++ {
+		assert.NoError(pw.Write(GroupSummary{GroupID: i, Name: strings.Repeat("x", 200)}))
+	}
+	assert.NoError(pw.Close())
+
+	meta, columns := readParquetFile(t, buf.Bytes())
+	assert.Equal(int64(rows), meta.NumRows)
+	assert.Greater(len(meta.RowGroups), 1, "rows should be flushed in small row groups")
+	assert.Len(columns["group_id"].values, rows)
+	assert.Equal(int64(rows-1), columns["group_id"].values[rows-1])
+}
+
+func Test_parquetWriter_empty(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	pw, err := newParquetWriter(&buf, reflect.TypeOf(GroupSummary{}), codec{})
+	assert.NoError(err)
+	assert.NoError(pw.Close())
+
+	meta, _ := readParquetFile(t, buf.Bytes())
+	assert.Equal(int64(0), meta.NumRows)
+	assert.Len(meta.Schema, 3)
+}
+
+func Test_savePages_parquet(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+trainingEnrollmentsURLPath, getTestHandler(exampleTrainingEnrollments))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{
+		APIBaseURL:    server.URL,
+		EntityOptions: map[string]EntityOptions{entityTrainingEnrollments: {Format: formatParquet}},
+		sink:          sink,
+	}
+	assert.NoError(getAndSaveTrainingEnrollments(config))
+
+	r, err := sink.Get("training/enrollments/knowbe4_training_enrollments.parquet")
+	assert.NoError(err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	assert.NoError(err)
+
+	meta, columns := readParquetFile(t, data)
+	assert.Equal(int64(1), meta.NumRows)
+	assert.Contains(columns, "user.email")
+
+	config.EntityOptions[entityTrainingEnrollments] = EntityOptions{Format: "avro"}
+	assert.Error(getAndSaveTrainingEnrollments(config))
+}
@@ -0,0 +1,113 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultConcurrency = 5
+
+// IDError is the error from processing one item in a batch, such as the recipients of one security test
+type IDError struct {
+	ID  int
+	Err error
+}
+
+// BatchError lists every item in a batch that failed. If the batch was aborted, Skipped is the
+// number of items that were never started.
+type BatchError struct {
+	Entity  string
+	Errors  []IDError
+	Aborted bool
+	Skipped int
+}
+
+func (e *BatchError) Error() string {
+	ids := make([]string, len(e.Errors))
+	details := make([]string, len(e.Errors))
+	for i, idErr := range e.Errors {
+		ids[i] = fmt.Sprint(idErr.ID)
+		details[i] = fmt.Sprintf("%v: %s", idErr.ID, idErr.Err)
+	}
+
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("%s aborted with %d not started", e.Entity, e.Skipped)
+	}
+
+	msg := fmt.Sprintf("%d %s failed (IDs %s)", len(e.Errors), e.Entity, strings.Join(ids, ", "))
+	if e.Aborted {
+		msg += fmt.Sprintf(", aborted with %d not started", e.Skipped)
+	}
+	return msg + " ... " + strings.Join(details, "; ")
+}
+
+// runWorkerPool calls work for each of the ids, concurrency at a time, giving up once maxErrors calls
+// have failed. A maxErrors of zero means never give up early.
+func runWorkerPool(ctx context.Context, entity string, ids []int, concurrency, maxErrors int,
+	work func(ctx context.Context, id int) error) *BatchError {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	batchErr := &BatchError{Entity: entity}
+
+	jobs := make(chan int)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				err := work(ctx, id)
+				if err == nil {
+					continue
+				}
+
+				mutex.Lock()
+				batchErr.Errors = append(batchErr.Errors, IDError{ID: id, Err: err})
+				if maxErrors > 0 && len(batchErr.Errors) >= maxErrors {
+					cancel()
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	started := 0
+dispatch:
+	for _, id := range ids {
+		// Check first, since select picks at random when a worker is also ready
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- id:
+			started++
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if started < len(ids) {
+		batchErr.Aborted = true
+		batchErr.Skipped = len(ids) - started
+	}
+
+	if len(batchErr.Errors) == 0 && !batchErr.Aborted {
+		return nil
+	}
+
+	sort.Slice(batchErr.Errors, func(i, j int) bool {
+		return batchErr.Errors[i].ID < batchErr.Errors[j].ID
+	})
+	return batchErr
+}
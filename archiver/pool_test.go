@@ -0,0 +1,117 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runWorkerPool_concurrentRequests(t *testing.T) {
+	assert := require.New(t)
+
+	const concurrency = 4
+
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		_, _ = w.Write([]byte("[" + exampleRecipient + "]"))
+	}))
+	defer server.Close()
+
+	config := LambdaConfig{APIBaseURL: server.URL, sink: newFileSink(t.TempDir())}
+	ids := []int{1, 2, 3, 4, 5, 6, 7, 8}
+
+	batchErr := runWorkerPool(context.Background(), "security tests", ids, concurrency, maxErrorsAllowed,
+		func(ctx context.Context, id int) error {
+			_, err := saveRecipientsForSecTest(KnowBe4SecurityTest{PstID: id}, config)
+			return err
+		})
+	assert.Nil(batchErr)
+	assert.Equal(concurrency, maxInFlight)
+}
+
+func Test_runWorkerPool_abort(t *testing.T) {
+	assert := require.New(t)
+
+	ids := make([]int, 100)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+
+	var mutex sync.Mutex
+	calls := 0
+	batchErr := runWorkerPool(context.Background(), "security tests", ids, 2, 3,
+		func(ctx context.Context, id int) error {
+			mutex.Lock()
+			calls++
+			mutex.Unlock()
+			return fmt.Errorf("failed %v", id)
+		})
+
+	assert.NotNil(batchErr)
+	assert.True(batchErr.Aborted)
+	assert.GreaterOrEqual(len(batchErr.Errors), 3)
+	assert.Equal(calls, len(batchErr.Errors))
+	assert.Equal(len(ids)-calls, batchErr.Skipped)
+	assert.Less(calls, 10)
+	assert.Contains(batchErr.Error(), "IDs 1, 2, 3")
+}
+
+func Test_runWorkerPool_collectsEveryFailure(t *testing.T) {
+	assert := require.New(t)
+
+	ids := []int{10, 20, 30, 40}
+	batchErr := runWorkerPool(context.Background(), "security tests", ids, 2, maxErrorsAllowed,
+		func(ctx context.Context, id int) error {
+			if id == 20 || id == 40 {
+				return errors.New("bad")
+			}
+			return nil
+		})
+
+	assert.NotNil(batchErr)
+	assert.False(batchErr.Aborted)
+	assert.Equal([]IDError{{ID: 20, Err: errors.New("bad")}, {ID: 40, Err: errors.New("bad")}}, batchErr.Errors)
+}
+
+func Test_runWorkerPool_cancelsInFlight(t *testing.T) {
+	assert := require.New(t)
+
+	ids := []int{1, 2, 3}
+	batchErr := runWorkerPool(context.Background(), "security tests", ids, 3, 1,
+		func(ctx context.Context, id int) error {
+			if id == 1 {
+				return errors.New("bad")
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				return nil
+			}
+		})
+
+	assert.NotNil(batchErr)
+	for _, e := range batchErr.Errors[1:] {
+		assert.True(errors.Is(e.Err, context.Canceled))
+	}
+}
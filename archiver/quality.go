@@ -0,0 +1,212 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const reconciliationReportPrefix = "quality/knowbe4_recipient_reconciliation_"
+
+// recipientCounter pairs a counter of a security test with the recipient timestamp it counts
+type recipientCounter struct {
+	name      string
+	testCount func(st *KnowBe4SecurityTest) int
+	timestamp func(r *KnowBe4Recipient) *time.Time
+}
+
+// recipientCounters are the counters of a security test that its recipients should add up to
+var recipientCounters = []recipientCounter{
+	{"scheduled", func(st *KnowBe4SecurityTest) int { return st.ScheduledCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.ScheduledAt }},
+	{"delivered", func(st *KnowBe4SecurityTest) int { return st.DeliveredCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.DeliveredAt }},
+	{"opened", func(st *KnowBe4SecurityTest) int { return st.OpenedCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.OpenedAt }},
+	{"clicked", func(st *KnowBe4SecurityTest) int { return st.ClickedCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.ClickedAt }},
+	{"replied", func(st *KnowBe4SecurityTest) int { return st.RepliedCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.RepliedAt }},
+	{"attachment_opened", func(st *KnowBe4SecurityTest) int { return st.AttachmentOpenCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.AttachmentOpenedAt }},
+	{"macro_enabled", func(st *KnowBe4SecurityTest) int { return st.MacroEnabledCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.MacroEnabledAt }},
+	{"data_entered", func(st *KnowBe4SecurityTest) int { return st.DataEnteredCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.DataEnteredAt }},
+	{"vulnerable_plugin", func(st *KnowBe4SecurityTest) int { return st.VulnerablePluginCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.VulnerablePluginsAt }},
+	{"exploited", func(st *KnowBe4SecurityTest) int { return st.ExploitedCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.ExploitedAt }},
+	{"reported", func(st *KnowBe4SecurityTest) int { return st.ReportedCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.ReportedAt }},
+	{"bounced", func(st *KnowBe4SecurityTest) int { return st.BouncedCount },
+		func(r *KnowBe4Recipient) *time.Time { return r.BouncedAt }},
+}
+
+// CountMismatch is a counter of a security test that doesn't match its recipients
+type CountMismatch struct {
+	Test       int `json:"test"`
+	Recipients int `json:"recipients"`
+}
+
+// TestReconciliation lists the counters of one security test that don't match its recipients
+type TestReconciliation struct {
+	PstID      int                      `json:"pst_id"`
+	Name       string                   `json:"name"`
+	Status     string                   `json:"status"`
+	Recipients int                      `json:"recipients"`
+	Mismatches map[string]CountMismatch `json:"mismatches"`
+}
+
+// ReconciliationReport lists the Closed security tests whose counters don't match their recipients. Open
+// tests are still counting, so their mismatches are listed separately and don't count towards a failure.
+type ReconciliationReport struct {
+	RunID           string               `json:"run_id"`
+	Checked         int                  `json:"checked"`
+	Mismatched      int                  `json:"mismatched"`
+	MismatchPercent float64              `json:"mismatch_percent"`
+	Tests           []TestReconciliation `json:"tests"`
+	OpenChecked     int                  `json:"open_checked"`
+	OpenTests       []TestReconciliation `json:"open_tests"`
+}
+
+// ReconciliationError is returned for recipients when too many security tests don't match them
+type ReconciliationError struct {
+	Report    ReconciliationReport
+	Threshold float64
+}
+
+func (e *ReconciliationError) Error() string {
+	return fmt.Sprintf("recipients of %d of %d security tests (%.1f%%) don't match the test counters, more "+
+		"than the %.1f%% allowed", e.Report.Mismatched, e.Report.Checked, e.Report.MismatchPercent, e.Threshold)
+}
+
+// recipientCounts counts the recipients of one security test, and how many have each timestamp set
+type recipientCounts struct {
+	recipients int
+	counts     []int
+}
+
+func newRecipientCounts() *recipientCounts {
+	return &recipientCounts{counts: make([]int, len(recipientCounters))}
+}
+
+func (c *recipientCounts) count(r *KnowBe4Recipient) {
+	c.recipients++
+	for i, counter := range recipientCounters {
+		if counter.timestamp(r) != nil {
+			c.counts[i]++
+		}
+	}
+}
+
+// reconciliation collects the recipient counts of each security test saved in a run
+type reconciliation struct {
+	mutex sync.Mutex
+	tests []reconciledTest
+}
+
+type reconciledTest struct {
+	test   KnowBe4SecurityTest
+	counts *recipientCounts
+}
+
+func newReconciliation() *reconciliation {
+	return &reconciliation{}
+}
+
+// add records the counts of the recipients saved for a security test
+func (r *reconciliation) add(st KnowBe4SecurityTest, counts *recipientCounts) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tests = append(r.tests, reconciledTest{test: st, counts: counts})
+}
+
+// report compares the counters of each security test added with its recipient counts
+func (r *reconciliation) report(runID string) ReconciliationReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := ReconciliationReport{RunID: runID, Tests: []TestReconciliation{}, OpenTests: []TestReconciliation{}}
+	for _, t := range r.tests {
+		closed := t.test.Status == securityTestStatusClosed
+		if closed {
+			report.Checked++
+		} else {
+			report.OpenChecked++
+		}
+
+		result := TestReconciliation{
+			PstID:      t.test.PstID,
+			Name:       t.test.Name,
+			Status:     t.test.Status,
+			Recipients: t.counts.recipients,
+			Mismatches: map[string]CountMismatch{},
+		}
+		for i, counter := range recipientCounters {
+			if want := counter.testCount(&t.test); want != t.counts.counts[i] {
+				result.Mismatches[counter.name] = CountMismatch{Test: want, Recipients: t.counts.counts[i]}
+			}
+		}
+		if len(result.Mismatches) > 0 && closed {
+			report.Tests = append(report.Tests, result)
+		} else if len(result.Mismatches) > 0 {
+			report.OpenTests = append(report.OpenTests, result)
+		}
+	}
+	sort.Slice(report.Tests, func(i, j int) bool { return report.Tests[i].PstID < report.Tests[j].PstID })
+	sort.Slice(report.OpenTests, func(i, j int) bool { return report.OpenTests[i].PstID < report.OpenTests[j].PstID })
+
+	report.Mismatched = len(report.Tests)
+	if report.Checked > 0 {
+		report.MismatchPercent = float64(report.Mismatched) * 100 / float64(report.Checked)
+	}
+	return report
+}
+
+// finish saves the reconciliation report, and fails recipients if too many tests don't match
+func (r *reconciliation) finish(config LambdaConfig, runErr *RunError) {
+	if r == nil {
+		return
+	}
+
+	report := r.report(config.runID)
+	if report.Checked == 0 && report.OpenChecked == 0 {
+		return
+	}
+	for _, t := range report.Tests {
+		var counts []string
+		for _, counter := range recipientCounters {
+			if m, ok := t.Mismatches[counter.name]; ok {
+				counts = append(counts, fmt.Sprintf("%s %d in test, %d in recipients", counter.name, m.Test,
+					m.Recipients))
+			}
+		}
+		log.Printf("recipients of security test %d don't match its counters ... %s", t.PstID,
+			strings.Join(counts, ", "))
+	}
+	log.Printf("reconciled recipients of %d closed security tests, %d don't match, and %d of %d open tests "+
+		"don't match yet", report.Checked, report.Mismatched, len(report.OpenTests), report.OpenChecked)
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = putObject(config, "recipient_reconciliation", reconciliationReportPrefix+config.runID+".json", b,
+			PutOptions{ContentType: "application/json"})
+	}
+	if err != nil {
+		runErr.add("recipient_reconciliation",
+			fmt.Errorf("error saving recipient reconciliation report ... %s", err))
+	}
+
+	threshold := config.ReconciliationFailPercent
+	if threshold > 0 && report.MismatchPercent > threshold {
+		runErr.add(entityRecipients, &ReconciliationError{Report: report, Threshold: threshold})
+	}
+}
@@ -0,0 +1,105 @@
+package archiver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_reconciliation_nil(t *testing.T) {
+	var r *reconciliation
+	r.add(KnowBe4SecurityTest{PstID: 1}, newRecipientCounts())
+	runErr := &RunError{}
+	r.finish(LambdaConfig{}, runErr)
+	require.Empty(t, runErr.Errors)
+}
+
+func Test_archive_recipientReconciliation(t *testing.T) {
+	assert := require.New(t)
+
+	// The fixture test's counters don't match its one recipient, so a copy is made that does
+	var mismatched KnowBe4SecurityTest
+	assert.NoError(json.Unmarshal([]byte(exampleSecurityTest), &mismatched))
+	matched := KnowBe4SecurityTest{PstID: 2, Name: "Matched", Status: "Closed", ScheduledCount: 1,
+		DeliveredCount: 1, OpenedCount: 1, ClickedCount: 1, RepliedCount: 1, DataEnteredCount: 1}
+	// Open tests are still counting, so their mismatches don't count towards a failure
+	open := KnowBe4SecurityTest{PstID: 3, Name: "Open", Status: "In Progress", ScheduledCount: 5}
+	tests, err := json.Marshal([]KnowBe4SecurityTest{mismatched, matched, open})
+	assert.NoError(err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+securityTestURLPath, getTestHandler(string(tests)))
+	for _, id := range []int{mismatched.PstID, matched.PstID, open.PstID} {
+		mux.HandleFunc("/"+fmt.Sprintf(recipientsURLPath, id), getTestHandler("["+exampleRecipient+"]"))
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{
+		APIBaseURL:                server.URL,
+		Entities:                  []string{entityRecipients},
+		ReconciliationFailPercent: 40,
+		runID:                     "run1",
+		reconciliation:            newReconciliation(),
+		sink:                      sink,
+	}
+	err = archive(config)
+
+	var runErr *RunError
+	assert.True(errors.As(err, &runErr))
+	assert.Len(runErr.Errors, 1)
+	assert.Equal(entityRecipients, runErr.Errors[0].Entity)
+	assert.IsType(&ReconciliationError{}, runErr.Errors[0].Err)
+	assert.Contains(err.Error(), "recipients of 1 of 2 security tests (50.0%)")
+
+	var report ReconciliationReport
+	readJSON(t, sink, reconciliationReportPrefix+"run1.json", &report)
+	assert.Equal(ReconciliationReport{
+		RunID:           "run1",
+		Checked:         2,
+		Mismatched:      1,
+		MismatchPercent: 50,
+		Tests: []TestReconciliation{{
+			PstID:      16142,
+			Name:       "Corporate Test",
+			Status:     "Closed",
+			Recipients: 1,
+			Mismatches: map[string]CountMismatch{
+				"scheduled":         {Test: 42, Recipients: 1},
+				"delivered":         {Test: 4, Recipients: 1},
+				"opened":            {Test: 24, Recipients: 1},
+				"clicked":           {Test: 20, Recipients: 1},
+				"replied":           {Test: 0, Recipients: 1},
+				"attachment_opened": {Test: 3, Recipients: 0},
+				"data_entered":      {Test: 0, Recipients: 1},
+				"exploited":         {Test: 2, Recipients: 0},
+			},
+		}},
+		OpenChecked: 1,
+		OpenTests: []TestReconciliation{{
+			PstID:      3,
+			Name:       "Open",
+			Status:     "In Progress",
+			Recipients: 1,
+			Mismatches: map[string]CountMismatch{
+				"scheduled":    {Test: 5, Recipients: 1},
+				"delivered":    {Test: 0, Recipients: 1},
+				"opened":       {Test: 0, Recipients: 1},
+				"clicked":      {Test: 0, Recipients: 1},
+				"replied":      {Test: 0, Recipients: 1},
+				"data_entered": {Test: 0, Recipients: 1},
+			},
+		}},
+	}, report)
+
+	// Under the threshold, mismatches are only reported
+	config.ReconciliationFailPercent = 50
+	config.reconciliation = newReconciliation()
+	assert.NoError(archive(config))
+}
@@ -0,0 +1,172 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KnowBe4 allows up to 4 requests per second on the Reporting API
+const defaultRequestsPerSecond = 4
+
+// ErrDailyBudgetExhausted is returned when a request would go over the configured daily API budget
+var ErrDailyBudgetExhausted = errors.New("daily API request budget exhausted")
+
+// rateLimiter is a token bucket shared by every API request in a run, which also counts requests
+// against a daily budget
+type rateLimiter struct {
+	mutex       sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	dailyBudget int
+	used        int
+	day         string
+
+	// unsaved counts the requests made today that aren't in the saved state yet
+	unsaved int
+}
+
+// newRateLimiter makes a limiter allowing requestsPerSecond on average. A dailyBudget of zero means
+// there is no daily limit.
+func newRateLimiter(requestsPerSecond float64, dailyBudget int) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		requestsPerSecond = defaultRequestsPerSecond
+	}
+	burst := requestsPerSecond
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	return &rateLimiter{
+		rate:        requestsPerSecond,
+		burst:       burst,
+		tokens:      burst,
+		last:        now,
+		dailyBudget: dailyBudget,
+		day:         now.UTC().Format("2006-01-02"),
+	}
+}
+
+// wait blocks until a request may be sent, and counts it against the daily budget
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	l.resetDay(now)
+	if l.dailyBudget > 0 && l.used >= l.dailyBudget {
+		used := l.used
+		l.mutex.Unlock()
+		return fmt.Errorf("%w (%d requests used)", ErrDailyBudgetExhausted, used)
+	}
+	l.refill(now)
+	l.tokens--
+	l.used++
+	l.unsaved++
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	if err := sleepContext(ctx, delay); err != nil {
+		// Give the reservation back, as the request won't be sent
+		l.mutex.Lock()
+		l.tokens++
+		l.used--
+		l.unsaved--
+		l.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// checkBudget returns an error if fewer than needed requests remain in today's budget
+func (l *rateLimiter) checkBudget(needed int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.resetDay(time.Now())
+	if l.dailyBudget == 0 {
+		return nil
+	}
+	if remaining := l.dailyBudget - l.used; remaining < needed {
+		return fmt.Errorf("%w: %d requests remaining but at least %d are needed",
+			ErrDailyBudgetExhausted, remaining, needed)
+	}
+	return nil
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+func (l *rateLimiter) resetDay(now time.Time) {
+	if day := now.UTC().Format("2006-01-02"); day != l.day {
+		l.day = day
+		l.used = 0
+		l.unsaved = 0
+	}
+}
+
+// loadUsage counts the requests state records for today against the daily budget
+func (l *rateLimiter) loadUsage(state *ArchiveState) {
+	if l == nil || l.dailyBudget == 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.resetDay(time.Now())
+	if used := state.apiRequests(l.day); used > l.used {
+		l.used = used
+	}
+}
+
+// saveUsage adds the requests made today that aren't saved yet to the count in state, which may include
+// requests made by other runs. It returns the number added, to be passed to usageSaved once state is
+// saved.
+func (l *rateLimiter) saveUsage(state *ArchiveState) int {
+	if l == nil || l.dailyBudget == 0 {
+		return 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.resetDay(time.Now())
+	if total := state.addAPIRequests(l.day, l.unsaved); total > l.used {
+		l.used = total
+	}
+	return l.unsaved
+}
+
+// usageSaved records that count requests from saveUsage are now in the saved state
+func (l *rateLimiter) usageSaved(count int) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.unsaved -= count
+	if l.unsaved < 0 {
+		l.unsaved = 0
+	}
+}
@@ -0,0 +1,175 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rateLimiter_wait(t *testing.T) {
+	assert := require.New(t)
+
+	l := newRateLimiter(20, 0)
+
+	start := time.Now()
+	for i := 0; i < 25; i++ {
+		assert.NoError(l.wait(context.Background()))
+	}
+
+	// The first 20 use up the burst, the next 5 come at 20 per second
+	assert.GreaterOrEqual(int64(time.Since(start)), int64(200*time.Millisecond))
+}
+
+func Test_rateLimiter_dailyBudget(t *testing.T) {
+	assert := require.New(t)
+
+	l := newRateLimiter(1000, 3)
+
+	assert.NoError(l.checkBudget(3))
+	for i := 0; i < 3; i++ {
+		assert.NoError(l.wait(context.Background()))
+	}
+
+	err := l.wait(context.Background())
+	assert.True(errors.Is(err, ErrDailyBudgetExhausted))
+
+	err = l.checkBudget(1)
+	assert.True(errors.Is(err, ErrDailyBudgetExhausted))
+}
+
+func Test_rateLimiter_waitCancelled(t *testing.T) {
+	assert := require.New(t)
+
+	l := newRateLimiter(1, 5)
+	assert.NoError(l.wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.True(errors.Is(l.wait(ctx), context.DeadlineExceeded))
+	assert.Less(int64(time.Since(start)), int64(500*time.Millisecond))
+	assert.Equal(1, l.used, "a cancelled wait shouldn't count against the budget")
+}
+
+func Test_rateLimiter_usageKeptInState(t *testing.T) {
+	assert := require.New(t)
+
+	store := newSinkStateStore(newFileSink(t.TempDir()))
+	config := LambdaConfig{APIDailyRequestLimit: 3, stateStore: store, limiter: newRateLimiter(1000, 3)}
+
+	assert.NoError(loadAPIUsage(config))
+	for i := 0; i < 2; i++ {
+		assert.NoError(config.limiter.wait(context.Background()))
+	}
+	assert.NoError(saveAPIUsage(config))
+
+	// The next run of the day starts with the requests already made
+	config.limiter = newRateLimiter(1000, 3)
+	assert.NoError(loadAPIUsage(config))
+	assert.NoError(config.limiter.wait(context.Background()))
+	assert.True(errors.Is(config.limiter.wait(context.Background()), ErrDailyBudgetExhausted))
+
+	// Requests from an earlier day don't count
+	state, err := store.Load()
+	assert.NoError(err)
+	assert.Equal(map[string]int{time.Now().UTC().Format("2006-01-02"): 2}, state.APIRequests)
+	state.APIRequests = map[string]int{"2020-01-01": 3}
+	assert.NoError(store.Save(state))
+	config.limiter = newRateLimiter(1000, 3)
+	assert.NoError(loadAPIUsage(config))
+	assert.NoError(config.limiter.checkBudget(3))
+}
+
+func Test_updateState_overlappingRuns(t *testing.T) {
+	assert := require.New(t)
+
+	store := newSinkStateStore(newFileSink(t.TempDir()))
+	first := LambdaConfig{APIDailyRequestLimit: 10, stateStore: store, limiter: newRateLimiter(1000, 10)}
+	second := LambdaConfig{APIDailyRequestLimit: 10, stateStore: store, limiter: newRateLimiter(1000, 10)}
+	assert.NoError(loadAPIUsage(first))
+	assert.NoError(loadAPIUsage(second))
+
+	now := time.Now().UTC()
+	err := updateState(first, func(state *ArchiveState) error {
+		for i := 0; i < 2; i++ {
+			assert.NoError(first.limiter.wait(context.Background()))
+		}
+		state.markArchived(1, securityTestStatusClosed, "one", now)
+
+		// The second run saves while the first is still going
+		return updateState(second, func(state *ArchiveState) error {
+			for i := 0; i < 3; i++ {
+				assert.NoError(second.limiter.wait(context.Background()))
+			}
+			state.markArchived(2, securityTestStatusClosed, "two", now)
+			state.markRiskScoreDate("users/1", "2026-10-01")
+			return nil
+		})
+	})
+	assert.NoError(err)
+
+	// Saving again doesn't count the same requests twice
+	assert.NoError(saveAPIUsage(first))
+
+	state, err := store.Load()
+	assert.NoError(err)
+	assert.Len(state.SecurityTests, 2)
+	assert.Equal("2026-10-01", state.RiskScoreDates["users/1"])
+	assert.Equal(map[string]int{now.Format("2006-01-02"): 5}, state.APIRequests)
+}
+
+func Test_archive_checksBudgetUpFront(t *testing.T) {
+	assert := require.New(t)
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	config := LambdaConfig{
+		APIBaseURL: server.URL,
+		Entities:   []string{entityGroups, entityUsers},
+		limiter:    newRateLimiter(1000, 1),
+	}
+	err := archive(config)
+	assert.True(errors.Is(err, ErrDailyBudgetExhausted))
+	assert.Equal(0, calls)
+}
+
+func Test_rateLimiter_nil(t *testing.T) {
+	var l *rateLimiter
+	require.NoError(t, l.wait(context.Background()))
+	require.NoError(t, l.checkBudget(1000))
+	l.loadUsage(newArchiveState())
+	l.saveUsage(newArchiveState())
+}
+
+func Test_callAPI_stopsAtDailyBudget(t *testing.T) {
+	assert := require.New(t)
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	config := LambdaConfig{APIBaseURL: server.URL, limiter: newRateLimiter(1000, 2)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := callAPI("v1/users", config, nil)
+		assert.NoError(err)
+		resp.Body.Close()
+	}
+
+	_, err := callAPI("v1/users", config, nil)
+	assert.True(errors.Is(err, ErrDailyBudgetExhausted))
+	assert.Equal(2, calls)
+}
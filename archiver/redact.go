@@ -0,0 +1,303 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Actions of a redaction policy
+const (
+	redactDrop         = "drop"
+	redactMask         = "mask"
+	redactPseudonymize = "pseudonymize"
+)
+
+// maskKeepRunes is the number of trailing characters mask leaves visible, e.g. the end of a phone number
+const maskKeepRunes = 4
+
+// entityRowTypes are the types saved in each entity's files, so redaction policies can be checked before
+// anything is fetched
+var entityRowTypes = map[string]reflect.Type{
+	entityAccount:                reflect.TypeOf(KnowBe4Account{}),
+	entityAccountRiskScores:      reflect.TypeOf(RiskScoreRow{}),
+	entityCampaigns:              reflect.TypeOf(KnowBe4Campaign{}),
+	entityGroups:                 reflect.TypeOf(KnowBe4Group{}),
+	entityGroupMembers:           reflect.TypeOf(KnowBe4GroupMember{}),
+	entityGroupRiskScores:        reflect.TypeOf(RiskScoreRow{}),
+	entityUsers:                  reflect.TypeOf(KnowBe4User{}),
+	entityUserRiskScores:         reflect.TypeOf(RiskScoreRow{}),
+	entityTrainingCampaigns:      reflect.TypeOf(KnowBe4TrainingCampaign{}),
+	entityTrainingEnrollments:    reflect.TypeOf(KnowBe4TrainingEnrollment{}),
+	entityTrainingPolicies:       reflect.TypeOf(KnowBe4Policy{}),
+	entityTrainingStorePurchases: reflect.TypeOf(KnowBe4StorePurchase{}),
+	entitySecurityTests:          reflect.TypeOf(KnowBe4SecurityTest{}),
+	entityRecipients:             reflect.TypeOf(KnowBe4Recipient{}),
+}
+
+// redactedField is a field named in a redaction policy, reached from the row through the fields in index
+type redactedField struct {
+	index  []int
+	path   []string
+	action string
+}
+
+// redactor applies the redaction policy of one entity. A nil *redactor leaves values as they are.
+type redactor struct {
+	fields []redactedField
+	key    []byte
+}
+
+// entityRedactor returns the redactor for the policy in an entity's options, or nil if it has none
+func (c LambdaConfig) entityRedactor(entity string, rowType reflect.Type) (*redactor, error) {
+	policy := c.EntityOptions[entity].Redact
+	if len(policy) == 0 {
+		return nil, nil
+	}
+
+	// Sort the paths so a bad policy always reports the same error
+	paths := make([]string, 0, len(policy))
+	for path := range policy {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	r := &redactor{key: []byte(c.PseudonymKey)}
+	for _, path := range paths {
+		action := strings.ToLower(policy[path])
+		field, err := newRedactedField(rowType, path, action)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction policy for %s ... %s", entity, err)
+		}
+		if action == redactPseudonymize && c.PseudonymKey == "" {
+			return nil, fmt.Errorf("redaction policy for %s pseudonymizes %s, but no pseudonym key is set", entity,
+				path)
+		}
+		r.fields = append(r.fields, field)
+	}
+	return r, nil
+}
+
+// newRedactedField finds the field at a dotted path of JSON names, like "user.email", in rowType
+func newRedactedField(rowType reflect.Type, path, action string) (redactedField, error) {
+	switch action {
+	case redactDrop, redactMask, redactPseudonymize:
+	default:
+		return redactedField{}, fmt.Errorf("unknown action %q for %s, must be %s, %s or %s", action, path,
+			redactDrop, redactMask, redactPseudonymize)
+	}
+
+	field := redactedField{path: strings.Split(path, "."), action: action}
+	t := rowType
+	for _, name := range field.path {
+		t = redactElem(t)
+		if t.Kind() != reflect.Struct || t == timeType {
+			return redactedField{}, fmt.Errorf("%s has no field %q", path, name)
+		}
+		i, ok := jsonFieldIndex(t, name)
+		if !ok {
+			return redactedField{}, fmt.Errorf("%s has no field %q", path, name)
+		}
+		field.index = append(field.index, i)
+		t = t.Field(i).Type
+	}
+
+	if action != redactDrop && redactElem(t).Kind() != reflect.String {
+		return redactedField{}, fmt.Errorf("can't %s %s, only text fields can be", action, path)
+	}
+	return field, nil
+}
+
+// redactElem returns the type reached through any slices and pointers of t
+func redactElem(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t
+}
+
+// jsonFieldIndex returns the index of the field of struct type t with the given JSON name
+func jsonFieldIndex(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fieldName := strings.Split(f.Tag.Get("json"), ",")[0]
+		if fieldName == "-" || !f.IsExported() {
+			continue
+		}
+		if fieldName == "" {
+			fieldName = f.Name
+		}
+		if fieldName == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// redact returns a copy of v with the policy applied, leaving v unchanged
+func (r *redactor) redact(v interface{}) interface{} {
+	if r == nil {
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	c := reflect.New(rv.Type()).Elem()
+	c.Set(rv)
+	for _, f := range r.fields {
+		r.apply(c, f.index, f.action)
+	}
+	return c.Interface()
+}
+
+// redactFor returns a copy of v with the policy applied for an output format. JSON Lines can hold nulls,
+// so dropped fields are null there rather than empty.
+func (r *redactor) redactFor(format string, v interface{}) (interface{}, error) {
+	v = r.redact(v)
+	if r == nil || format != formatJSONLines {
+		return v, nil
+	}
+
+	var drops [][]string
+	for _, f := range r.fields {
+		if f.action == redactDrop {
+			drops = append(drops, f.path)
+		}
+	}
+	if len(drops) == 0 {
+		return v, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	for _, path := range drops {
+		setNull(doc, path)
+	}
+	return doc, nil
+}
+
+// setNull sets the field at path in decoded JSON to null, in every item of any arrays along the way
+func setNull(v interface{}, path []string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if _, ok := t[path[0]]; !ok {
+			return
+		}
+		if len(path) == 1 {
+			t[path[0]] = nil
+			return
+		}
+		setNull(t[path[0]], path[1:])
+	case []interface{}:
+		for _, item := range t {
+			setNull(item, path)
+		}
+	}
+}
+
+// apply redacts the field reached from the settable value v through index
+func (r *redactor) apply(v reflect.Value, index []int, action string) {
+	if len(index) == 0 && action == redactDrop {
+		// Dropped fields are emptied rather than removed, so every format keeps its columns
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(v.Elem())
+		v.Set(c)
+		r.apply(c.Elem(), index, action)
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		v.Set(c)
+		for i := 0; i < c.Len(); i++ {
+			r.apply(c.Index(i), index, action)
+		}
+	case reflect.Struct:
+		r.apply(v.Field(index[0]), index[1:], action)
+	case reflect.String:
+		if action == redactMask {
+			v.SetString(maskValue(v.String()))
+		} else {
+			v.SetString(r.pseudonym(v.String()))
+		}
+	}
+}
+
+// pseudonym returns the keyed HMAC-SHA256 of a value, ignoring case and surrounding space
+func (r *redactor) pseudonym(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// maskValue hides a value, keeping the domain of an email address or the last few characters of others
+func maskValue(s string) string {
+	if s == "" {
+		return ""
+	}
+	if at := strings.LastIndex(s, "@"); at >= 0 {
+		return "***" + s[at:]
+	}
+	n := utf8.RuneCountInString(s)
+	if n <= maskKeepRunes {
+		return strings.Repeat("*", n)
+	}
+	runes := []rune(s)
+	return strings.Repeat("*", n-maskKeepRunes) + string(runes[n-maskKeepRunes:])
+}
+
+// validateRedaction checks the redaction policy of every entity. Raw responses would keep redacted
+// fields, so they need a separate redacted destination, and a redacted destination without any policy
+// would get a full copy of the archive.
+func (c LambdaConfig) validateRedaction() error {
+	redacted := false
+	for _, entity := range allEntities {
+		r, err := c.entityRedactor(entity, entityRowTypes[entity])
+		if err != nil {
+			return err
+		}
+		if r != nil && c.RawResponses && c.RedactedDestination == "" {
+			return fmt.Errorf("raw responses would keep the fields redacted from %s, set a redacted "+
+				"destination to save them separately", entity)
+		}
+		redacted = redacted || r != nil
+	}
+	if c.RedactedDestination != "" && !redacted {
+		return errors.New("a redacted destination is set but no entity has a redaction policy, " +
+			"so its copies would not be redacted")
+	}
+	for entity := range c.EntityOptions {
+		if _, ok := entityRowTypes[entity]; !ok && len(c.EntityOptions[entity].Redact) > 0 {
+			return fmt.Errorf("unknown entity %q in redaction policy", entity)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LambdaConfig_entityRedactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		entity  string
+		policy  map[string]string
+		key     string
+		wantErr bool
+	}{
+		{name: "no policy", entity: entityUsers},
+		{name: "nested", entity: entityRecipients, policy: map[string]string{"user.email": "mask", "ip": "Drop"}},
+		{name: "slice of structs", entity: entityAccount, policy: map[string]string{"admins.email": "pseudonymize"},
+			key: "k"},
+		{name: "pointer", entity: entityRecipients, policy: map[string]string{"user.active_directory_guid": "mask"}},
+		{name: "drop a number", entity: entityGroupMembers, policy: map[string]string{"user_id": "drop"}},
+		{name: "unknown field", entity: entityUsers, policy: map[string]string{"mail": "drop"}, wantErr: true},
+		{name: "unknown action", entity: entityUsers, policy: map[string]string{"email": "hash"}, wantErr: true},
+		{name: "path through a leaf", entity: entityUsers, policy: map[string]string{"email.domain": "drop"},
+			wantErr: true},
+		{name: "mask a number", entity: entityUsers, policy: map[string]string{"id": "mask"}, wantErr: true},
+		{name: "no key", entity: entityUsers, policy: map[string]string{"email": "pseudonymize"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := LambdaConfig{
+				EntityOptions: map[string]EntityOptions{tt.entity: {Redact: tt.policy}},
+				PseudonymKey:  tt.key,
+			}
+			r, err := config.entityRedactor(tt.entity, entityRowTypes[tt.entity])
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, len(tt.policy) == 0, r == nil)
+		})
+	}
+}
+
+func Test_LambdaConfig_validateRedaction(t *testing.T) {
+	assert := require.New(t)
+
+	policy := map[string]EntityOptions{entityUsers: {Redact: map[string]string{"phone_number": "drop"}}}
+	assert.NoError(LambdaConfig{EntityOptions: policy}.validateRedaction())
+	assert.Error(LambdaConfig{EntityOptions: policy, RawResponses: true}.validateRedaction())
+	assert.NoError(LambdaConfig{EntityOptions: policy, RawResponses: true,
+		RedactedDestination: "file:///tmp/redacted"}.validateRedaction())
+
+	unknown := map[string]EntityOptions{"people": {Redact: map[string]string{"email": "drop"}}}
+	assert.Error(LambdaConfig{EntityOptions: unknown}.validateRedaction())
+
+	err := LambdaConfig{RedactedDestination: "file:///tmp/redacted"}.validateRedaction()
+	assert.Error(err, "a redacted destination without policies would get a full copy")
+	assert.Contains(err.Error(), "no entity has a redaction policy")
+	pageSize := map[string]EntityOptions{entityUsers: {PageSize: 250}}
+	assert.Error(LambdaConfig{EntityOptions: pageSize, RedactedDestination: "file:///tmp/redacted"}.validateRedaction())
+}
+
+func Test_LambdaConfig_init_redactionFromEnv(t *testing.T) {
+	assert := require.New(t)
+
+	t.Setenv(EnvAPIBaseURL, "http://localhost")
+	t.Setenv(EnvAPIAuthToken, "token")
+	t.Setenv(EnvDestination, "file://"+t.TempDir())
+	t.Setenv(EnvRedactedDestination, "file://"+t.TempDir())
+
+	config := LambdaConfig{}
+	assert.Error(config.init(), "a redacted destination needs a policy")
+
+	t.Setenv(EnvEntityOptions, `{"users": {"Redact": {"phone_number": "drop"}}}`)
+	config = LambdaConfig{}
+	assert.NoError(config.init())
+	assert.Equal(map[string]string{"phone_number": "drop"}, config.EntityOptions[entityUsers].Redact)
+	assert.NotNil(config.redactedSink)
+
+	t.Setenv(EnvEntityOptions, `{"users": {"Redact": "drop"}}`)
+	config = LambdaConfig{}
+	assert.Error(config.init())
+}
+
+func Test_redactor_redact(t *testing.T) {
+	assert := require.New(t)
+
+	var account KnowBe4Account
+	assert.NoError(json.Unmarshal([]byte(exampleAccount), &account))
+	var recipient KnowBe4Recipient
+	assert.NoError(json.Unmarshal([]byte(exampleRecipient), &recipient))
+	guid := "4a2b"
+	recipient.User.ActiveDirectoryGUID = &guid
+	recipient.IP = "10.20.30.40"
+
+	config := LambdaConfig{
+		EntityOptions: map[string]EntityOptions{
+			entityAccount: {Redact: map[string]string{"admins.email": "pseudonymize", "admins.last_name": "drop"}},
+			entityRecipients: {Redact: map[string]string{
+				"user.email":                 "pseudonymize",
+				"user.first_name":            "drop",
+				"user.active_directory_guid": "mask",
+				"ip":                         "mask",
+			}},
+		},
+		PseudonymKey: "secret",
+	}
+
+	r, err := config.entityRedactor(entityAccount, reflect.TypeOf(account))
+	assert.NoError(err)
+	got := r.redact(account).(KnowBe4Account)
+	assert.Len(got.Admins, 1)
+	assert.Empty(got.Admins[0].LastName)
+	assert.Equal(account.Admins[0].FirstName, got.Admins[0].FirstName)
+	assert.Len(got.Admins[0].Email, 32)
+	assert.NotEqual(account.Admins[0].LastName, "", "the original should be unchanged")
+	assert.Contains(account.Admins[0].Email, "@")
+
+	r, err = config.entityRedactor(entityRecipients, reflect.TypeOf(recipient))
+	assert.NoError(err)
+	gotRecipient := r.redact(recipient).(KnowBe4Recipient)
+	assert.Empty(gotRecipient.User.FirstName)
+	assert.Equal(recipient.User.LastName, gotRecipient.User.LastName)
+	assert.Equal("*******0.40", gotRecipient.IP)
+	assert.Equal("****", *gotRecipient.User.ActiveDirectoryGUID)
+	assert.Equal("4a2b", guid, "the original should be unchanged")
+
+	// The same address gets the same pseudonym whichever entity it is in, ignoring case
+	assert.Equal(r.pseudonym(" Bob.R@kb4-demo.com"), gotRecipient.User.Email)
+	assert.NotEqual((&redactor{key: []byte("other")}).pseudonym(recipient.User.Email), gotRecipient.User.Email)
+	assert.Empty(r.pseudonym(""))
+
+	var nilRedactor *redactor
+	assert.Equal(recipient, nilRedactor.redact(recipient))
+
+	// Dropped fields are null in JSON Lines, through slices too
+	r, err = config.entityRedactor(entityAccount, reflect.TypeOf(account))
+	assert.NoError(err)
+	v, err := r.redactFor(formatJSONLines, account)
+	assert.NoError(err)
+	b, err := json.Marshal(v)
+	assert.NoError(err)
+	assert.Contains(string(b), `"last_name":null`)
+	assert.NotContains(string(b), account.Admins[0].Email)
+
+	v, err = r.redactFor(formatCSV, account)
+	assert.NoError(err)
+	assert.Empty(v.(KnowBe4Account).Admins[0].LastName)
+}
+
+func Test_maskValue(t *testing.T) {
+	tests := map[string]string{
+		"":                      "",
+		"Bob":                   "***",
+		"555-554-2222":          "********2222",
+		"wmarcoux@kb4-demo.com": "***@kb4-demo.com",
+		"Ünïcödé":               "***cödé",
+	}
+	for value, want := range tests {
+		require.Equal(t, want, maskValue(value), value)
+	}
+}
+
+func Test_archive_redactedDestination(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+groupsURLPath, getTestHandler(exampleGroups))
+	mux.HandleFunc("/"+fmt.Sprintf(groupMembersURLPath, 2184841), getTestHandler("[]"))
+	mux.HandleFunc("/"+fmt.Sprintf(groupMembersURLPath, 1629520), getTestHandler(exampleUsers))
+	mux.HandleFunc("/"+usersURLPath, getTestHandler(exampleUsers))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	redactedSink := newFileSink(t.TempDir())
+	config := LambdaConfig{
+		APIBaseURL: server.URL,
+		Entities:   []string{entityGroups, entityGroupMembers, entityUsers},
+		EntityOptions: map[string]EntityOptions{
+			entityUsers: {Redact: map[string]string{
+				"email":        "pseudonymize",
+				"phone_number": "drop",
+				"first_name":   "drop",
+			}},
+			entityGroupMembers: {Redact: map[string]string{"email": "pseudonymize"}, Format: formatCSV},
+		},
+		PseudonymKey: "secret",
+		runID:        "run1",
+		manifest:     newRunManifest("run1", time.Now().UTC()),
+		sink:         sink,
+		redactedSink: redactedSink,
+	}
+	assert.NoError(archive(config))
+
+	today := time.Now().Format("2006-01-02")
+	usersKey := usersFilenamePrefix + today + ".jsonl"
+
+	var user, redactedUser KnowBe4User
+	readJSON(t, sink, usersKey, &user)
+	readJSON(t, redactedSink, usersKey, &redactedUser)
+	var redactedFields map[string]interface{}
+	readJSON(t, redactedSink, usersKey, &redactedFields)
+	assert.Contains(redactedFields, "phone_number")
+	assert.Nil(redactedFields["phone_number"], "dropped fields should be null")
+	assert.Equal("wmarcoux@kb4-demo.com", user.Email)
+	assert.Equal("555-554-2222", user.PhoneNumber)
+	assert.Empty(redactedUser.PhoneNumber)
+	assert.Empty(redactedUser.FirstName)
+	assert.Equal(user.LastName, redactedUser.LastName)
+
+	pseudonym := (&redactor{key: []byte("secret")}).pseudonym(user.Email)
+	assert.Equal(pseudonym, redactedUser.Email)
+
+	// Group members are joined to users by the same pseudonym
+	membersKey := groupMembersPrefix + today + ".csv"
+	members := string(readJSON(t, redactedSink, membersKey, nil))
+	assert.Contains(members, ","+pseudonym+",")
+	assert.Contains(string(readJSON(t, sink, membersKey, nil)), "wmarcoux@kb4-demo.com")
+
+	// Groups have no policy, so their copy is the same
+	assert.Equal(readJSON(t, sink, groupsFilename, nil), readJSON(t, redactedSink, groupsFilename, nil))
+
+	var manifest Manifest
+	readJSON(t, sink, manifestPrefix+"run1.json", &manifest)
+	assert.Len(manifest.Files, 6)
+	copies := 0
+	for _, f := range manifest.Files {
+		if f.RedactedCopy {
+			copies++
+		}
+	}
+	assert.Equal(3, copies)
+	assert.Equal(int64(1), manifest.Entities[entityUsers].Records)
+	assert.Equal(1, manifest.Entities[entityUsers].Files)
+
+	_, err := redactedSink.Stat(manifestPrefix + "run1.json")
+	assert.Error(err, "the manifest should only be saved to the destination")
+}
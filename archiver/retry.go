@@ -0,0 +1,142 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts     = 5
+	defaultRetryMaxElapsedTime  = 2 * time.Minute
+	defaultRetryInitialInterval = 500 * time.Millisecond
+	defaultRetryMaxInterval     = 30 * time.Second
+)
+
+// retrySleep is swapped out by tests so they don't have to wait for real backoff delays
+var retrySleep = sleepContext
+
+// sleepContext waits for d, returning ctx.Err() early if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// APIStatusError is returned for a single API request that received a non-2xx status
+type APIStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Body       []byte
+	RetryAfter time.Duration
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("API returned an error. URL: %s, Code: %v, Status: %s Body: %s",
+		e.URL, e.StatusCode, e.Status, e.Body)
+}
+
+// APIAttempt records the outcome of one try at an API request
+type APIAttempt struct {
+	Number     int
+	StatusCode int
+	Err        error
+	Wait       time.Duration
+}
+
+// RetryError is returned by callAPI when a request failed on every attempt it was allowed
+type RetryError struct {
+	URL      string
+	Attempts []APIAttempt
+	Reason   string
+}
+
+func (e *RetryError) Error() string {
+	msgs := make([]string, len(e.Attempts))
+	for i, a := range e.Attempts {
+		msgs[i] = fmt.Sprintf("attempt %d: %s", a.Number, a.Err)
+	}
+	return fmt.Sprintf("giving up on %s after %d attempt(s) (%s) ... %s",
+		e.URL, len(e.Attempts), e.Reason, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the error from the final attempt
+func (e *RetryError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// retryPolicy holds the resolved retry settings for a run
+type retryPolicy struct {
+	maxAttempts     int
+	maxElapsedTime  time.Duration
+	initialInterval time.Duration
+	maxInterval     time.Duration
+}
+
+func newRetryPolicy(config LambdaConfig) retryPolicy {
+	p := retryPolicy{
+		maxAttempts:     defaultRetryMaxAttempts,
+		maxElapsedTime:  defaultRetryMaxElapsedTime,
+		initialInterval: defaultRetryInitialInterval,
+		maxInterval:     defaultRetryMaxInterval,
+	}
+	if config.RetryMaxAttempts > 0 {
+		p.maxAttempts = config.RetryMaxAttempts
+	}
+	if config.RetryMaxElapsedSeconds > 0 {
+		p.maxElapsedTime = time.Duration(config.RetryMaxElapsedSeconds) * time.Second
+	}
+	return p
+}
+
+// backoff returns the delay before the given retry (1-based), using "full jitter"
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := p.initialInterval << uint(retry-1)
+	if d <= 0 || d > p.maxInterval {
+		d = p.maxInterval
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// isRetryable reports whether a failed attempt is worth repeating: transport errors, 429 and 5xx responses
+func isRetryable(err error) bool {
+	var statusErr *APIStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+}
+
+// parseRetryAfter handles both forms allowed for the Retry-After header: delay-seconds and HTTP-date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
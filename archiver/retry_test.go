@@ -0,0 +1,199 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubRetrySleep records requested backoff delays instead of sleeping
+func stubRetrySleep(t *testing.T) *[]time.Duration {
+	var waits []time.Duration
+	orig := retrySleep
+	retrySleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	t.Cleanup(func() { retrySleep = orig })
+	return &waits
+}
+
+// getSequenceTestServer responds with the given status codes in order, then 200 with body "[]"
+func getSequenceTestServer(t *testing.T, header http.Header, codes ...int) (string, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= len(codes) {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(codes[calls-1])
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &calls
+}
+
+func Test_callAPI_retriesServerErrors(t *testing.T) {
+	assert := require.New(t)
+	waits := stubRetrySleep(t)
+
+	testURL, calls := getSequenceTestServer(t, nil, http.StatusBadGateway, http.StatusServiceUnavailable)
+
+	resp, err := callAPI("v1/users", LambdaConfig{APIBaseURL: testURL}, nil)
+	assert.NoError(err)
+	resp.Body.Close()
+
+	assert.Equal(3, *calls)
+	assert.Len(*waits, 2)
+	for _, w := range *waits {
+		assert.LessOrEqual(int64(w), int64(defaultRetryMaxInterval))
+	}
+}
+
+func Test_callAPI_honorsRetryAfter(t *testing.T) {
+	assert := require.New(t)
+	waits := stubRetrySleep(t)
+
+	header := http.Header{"Retry-After": []string{"7"}}
+	testURL, calls := getSequenceTestServer(t, header, http.StatusTooManyRequests)
+
+	resp, err := callAPI("v1/users", LambdaConfig{APIBaseURL: testURL}, nil)
+	assert.NoError(err)
+	resp.Body.Close()
+
+	assert.Equal(2, *calls)
+	assert.Equal([]time.Duration{7 * time.Second}, *waits)
+}
+
+func Test_callAPI_doesNotRetryClientErrors(t *testing.T) {
+	assert := require.New(t)
+	stubRetrySleep(t)
+
+	testURL, calls := getSequenceTestServer(t, nil, http.StatusNotFound)
+
+	_, err := callAPI("v1/users", LambdaConfig{APIBaseURL: testURL}, nil)
+	assert.Error(err)
+	assert.Equal(1, *calls)
+
+	var retryErr *RetryError
+	assert.True(errors.As(err, &retryErr))
+	assert.Len(retryErr.Attempts, 1)
+	assert.Equal(http.StatusNotFound, retryErr.Attempts[0].StatusCode)
+}
+
+func Test_callAPI_maxAttempts(t *testing.T) {
+	assert := require.New(t)
+	stubRetrySleep(t)
+
+	testURL, calls := getSequenceTestServer(t, nil, 500, 500, 500, 500)
+
+	_, err := callAPI("v1/users", LambdaConfig{APIBaseURL: testURL, RetryMaxAttempts: 3}, nil)
+	assert.Error(err)
+	assert.Equal(3, *calls)
+
+	var retryErr *RetryError
+	assert.True(errors.As(err, &retryErr))
+	assert.Len(retryErr.Attempts, 3)
+
+	var statusErr *APIStatusError
+	assert.True(errors.As(err, &statusErr), "should unwrap to the last attempt's error")
+	assert.Equal(500, statusErr.StatusCode)
+}
+
+func Test_callAPI_timeBudget(t *testing.T) {
+	assert := require.New(t)
+	waits := stubRetrySleep(t)
+
+	header := http.Header{"Retry-After": []string{"60"}}
+	testURL, calls := getSequenceTestServer(t, header, http.StatusTooManyRequests)
+
+	_, err := callAPI("v1/users", LambdaConfig{APIBaseURL: testURL, RetryMaxElapsedSeconds: 10}, nil)
+	assert.Error(err)
+	assert.Equal(1, *calls)
+	assert.Empty(*waits)
+	assert.Contains(err.Error(), "retry time budget exhausted")
+}
+
+func Test_callAPI_cancelledDuringBackoff(t *testing.T) {
+	assert := require.New(t)
+
+	header := http.Header{"Retry-After": []string{"30"}}
+	testURL, calls := getSequenceTestServer(t, header, http.StatusTooManyRequests)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := callAPI("v1/users", LambdaConfig{APIBaseURL: testURL, ctx: ctx}, nil)
+	assert.True(errors.Is(err, context.Canceled))
+	assert.Equal(1, *calls)
+	assert.Less(int64(time.Since(start)), int64(5*time.Second))
+}
+
+// getHangingTestServer returns the URL of a server that doesn't answer until the request is abandoned
+func getHangingTestServer(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func Test_callAPI_timesOutHungRequest(t *testing.T) {
+	assert := require.New(t)
+	stubRetrySleep(t)
+
+	start := time.Now()
+	_, err := callAPI("v1/users", LambdaConfig{APIBaseURL: getHangingTestServer(t), RetryMaxElapsedSeconds: 1}, nil)
+	assert.Less(int64(time.Since(start)), int64(5*time.Second))
+
+	var retryErr *RetryError
+	assert.True(errors.As(err, &retryErr))
+	assert.Equal("retry time budget exhausted", retryErr.Reason)
+	assert.Len(retryErr.Attempts, 1)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+}
+
+func Test_callAPI_cancelledDuringRequest(t *testing.T) {
+	assert := require.New(t)
+	stubRetrySleep(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := callAPI("v1/users", LambdaConfig{APIBaseURL: getHangingTestServer(t), ctx: ctx}, nil)
+
+	var retryErr *RetryError
+	assert.True(errors.As(err, &retryErr))
+	assert.Equal("cancelled", retryErr.Reason)
+	assert.True(errors.Is(err, context.Canceled))
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "empty", value: "", want: 0},
+		{name: "seconds", value: "3", want: 3 * time.Second},
+		{name: "negative", value: "-3", want: 0},
+		{name: "http date", value: "Fri, 16 Oct 2026 12:00:30 GMT", want: 30 * time.Second},
+		{name: "past date", value: "Fri, 16 Oct 2026 11:00:00 GMT", want: 0},
+		{name: "garbage", value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parseRetryAfter(tt.value, now))
+		})
+	}
+}
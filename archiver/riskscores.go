@@ -0,0 +1,128 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	riskScoreEntityTypeAccount = "account"
+	riskScoreEntityTypeGroup   = "group"
+	riskScoreEntityTypeUser    = "user"
+)
+
+// getRiskScoreHistory gets the full risk score history of the account, a user or a group
+func getRiskScoreHistory(config LambdaConfig, opts PageOptions) ([]RiskScoreHistory, error) {
+	opts.Params = map[string]string{"full": "true"}
+	return getObject[[]RiskScoreHistory](config, opts)
+}
+
+// getAndSaveGroupRiskScores saves the risk score history of every group
+func getAndSaveGroupRiskScores(config LambdaConfig, groups []GroupSummary) error {
+	if groups == nil {
+		var err error
+		if groups, err = getGroupSummaries(config); err != nil {
+			return errors.New("error getting groups from KnowBe4 ..." + err.Error())
+		}
+	}
+
+	ids := make([]int, len(groups))
+	for i, g := range groups {
+		ids[i] = g.GroupID
+	}
+
+	return saveRiskScores(config, entityGroupRiskScores, riskScoreEntityTypeGroup, ids,
+		groupRiskScoreHistoryURLPath, groupRiskScoresPrefix)
+}
+
+// getAndSaveUserRiskScores saves the risk score history of every user
+func getAndSaveUserRiskScores(config LambdaConfig, userIDs []int) error {
+	if userIDs == nil {
+		err := Paginate(config, PageOptions{Entity: entityUsers, Path: usersURLPath}, func(p Page[KnowBe4User]) error {
+			for _, u := range p.Items {
+				userIDs = append(userIDs, u.Id)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.New("error getting users from KnowBe4 ..." + err.Error())
+		}
+	}
+
+	return saveRiskScores(config, entityUserRiskScores, riskScoreEntityTypeUser, userIDs,
+		userRiskScoreHistoryURLPath, userRiskScoresPrefix)
+}
+
+// saveRiskScores saves the risk score history of each of the ids as flattened rows in a dated file
+func saveRiskScores(config LambdaConfig, entity, entityType string, ids []int, urlPathFormat, prefix string) error {
+	if err := config.limiter.checkBudget(len(ids)); err != nil {
+		return errors.New("not enough API budget left to save risk scores ... " + err.Error())
+	}
+
+	sinceLast := config.EntityOptions[entity].SinceLastArchived
+	fileName := config.objectKey(entity, prefix+time.Now().Format("2006-01-02")+".jsonl", 0)
+	if sinceLast {
+		// Each run only has the rows added since the one before, so it gets its own file rather than
+		// replacing the rows an earlier run saved that day
+		fileName = strings.TrimSuffix(fileName, jsonLinesExtension) + "_" + config.runID + jsonLinesExtension
+	}
+
+	return updateState(config, func(state *ArchiveState) error {
+		var mutex sync.Mutex
+		latest := map[string]string{}
+
+		count, err := saveForEachID(config, entity, ids, fileName,
+			func(jobConfig LambdaConfig, id int, write func(v RiskScoreRow) error) error {
+				history, err := getRiskScoreHistory(jobConfig, config.objectOptions(entity, fmt.Sprintf(urlPathFormat, id), id))
+				var statusErr *APIStatusError
+				if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+					// The user or group was deleted since it was listed
+					log.Printf("skipping %s risk scores for %s %d, it was not found", entityType, entityType, id)
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+
+				key := fmt.Sprintf("%ss/%d", entityType, id)
+				after := ""
+				if sinceLast {
+					after = state.lastRiskScoreDate(key)
+				}
+
+				newest := ""
+				for _, h := range history {
+					if h.Date <= after {
+						continue
+					}
+					row := RiskScoreRow{EntityType: entityType, EntityID: id, Date: h.Date, RiskScore: h.RiskScore}
+					if err := write(row); err != nil {
+						return err
+					}
+					if h.Date > newest {
+						newest = h.Date
+					}
+				}
+
+				mutex.Lock()
+				latest[key] = newest
+				mutex.Unlock()
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+
+		for key, date := range latest {
+			state.markRiskScoreDate(key, date)
+		}
+
+		log.Printf("saved %d %s risk scores for %d %ss", count, entityType, len(ids), entityType)
+		return nil
+	})
+}
@@ -0,0 +1,154 @@
+package archiver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const exampleRiskScoreHistory = `[
+  {
+    "risk_score": 32.7108,
+    "date": "2020-11-06"
+  },
+  {
+    "risk_score": 32.6839,
+    "date": "2020-11-07"
+  }
+]`
+
+func readRiskScoreRows(t *testing.T, sink Sink, key string) []RiskScoreRow {
+	r, err := sink.Get(key)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var rows []RiskScoreRow
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var row RiskScoreRow
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func Test_getAndSaveUserRiskScores(t *testing.T) {
+	assert := require.New(t)
+
+	history := exampleRiskScoreHistory
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+fmt.Sprintf(userRiskScoreHistoryURLPath, 667542), func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("true", r.URL.Query().Get("full"))
+		_, _ = w.Write([]byte(history))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	store := &memoryStateStore{}
+	config := LambdaConfig{
+		APIBaseURL:    server.URL,
+		EntityOptions: map[string]EntityOptions{entityUserRiskScores: {SinceLastArchived: true}},
+		runID:         "run1",
+		sink:          sink,
+		stateStore:    store,
+	}
+	date := time.Now().Format("2006-01-02")
+
+	assert.NoError(getAndSaveUserRiskScores(config, []int{667542}))
+	assert.Equal([]RiskScoreRow{
+		{EntityType: "user", EntityID: 667542, Date: "2020-11-06", RiskScore: 32.7108},
+		{EntityType: "user", EntityID: 667542, Date: "2020-11-07", RiskScore: 32.6839},
+	}, readRiskScoreRows(t, sink, userRiskScoresPrefix+date+"_run1.jsonl"))
+	assert.Equal("2020-11-07", store.state.lastRiskScoreDate("users/667542"))
+
+	// The next run the same day only saves the new date, in a file of its own
+	history = `[{"risk_score": 32.7108, "date": "2020-11-06"}, {"risk_score": 32.6839, "date": "2020-11-07"},
+		{"risk_score": 33.0323, "date": "2020-11-08"}]`
+	config.runID = "run2"
+	assert.NoError(getAndSaveUserRiskScores(config, []int{667542}))
+	assert.Equal([]RiskScoreRow{
+		{EntityType: "user", EntityID: 667542, Date: "2020-11-08", RiskScore: 33.0323},
+	}, readRiskScoreRows(t, sink, userRiskScoresPrefix+date+"_run2.jsonl"))
+	assert.Equal("2020-11-08", store.state.lastRiskScoreDate("users/667542"))
+
+	// No row is lost between the two runs
+	objects, err := sink.List(userRiskScoresPrefix)
+	assert.NoError(err)
+	var dates []string
+	for _, o := range objects {
+		for _, row := range readRiskScoreRows(t, sink, o.Key) {
+			dates = append(dates, row.Date)
+		}
+	}
+	assert.Equal([]string{"2020-11-06", "2020-11-07", "2020-11-08"}, dates)
+
+	// Without the option, the full history is saved again
+	config.EntityOptions = nil
+	assert.NoError(getAndSaveUserRiskScores(config, []int{667542}))
+	assert.Len(readRiskScoreRows(t, sink, userRiskScoresPrefix+date+".jsonl"), 3)
+}
+
+func Test_getAndSaveGroupRiskScores_failure(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+fmt.Sprintf(groupRiskScoreHistoryURLPath, 1), getTestHandler(exampleRiskScoreHistory))
+	mux.HandleFunc("/"+fmt.Sprintf(groupRiskScoreHistoryURLPath, 2), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	store := &memoryStateStore{}
+	config := LambdaConfig{APIBaseURL: server.URL, RetryMaxAttempts: 1, sink: sink, stateStore: store}
+
+	err := getAndSaveGroupRiskScores(config, []GroupSummary{{GroupID: 1}, {GroupID: 2}})
+	assert.Error(err)
+
+	assert.Equal("", store.state.lastRiskScoreDate("groups/1"), "nothing should be marked if the file wasn't saved")
+	objects, err := sink.List("risk_scores/")
+	assert.NoError(err)
+	assert.Empty(objects)
+}
+
+func Test_getAndSaveGroupRiskScores_skipsNotFound(t *testing.T) {
+	assert := require.New(t)
+
+	// The second group is not served, as if it was deleted after the groups were listed
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+fmt.Sprintf(groupRiskScoreHistoryURLPath, 1), getTestHandler(exampleRiskScoreHistory))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sink := newFileSink(t.TempDir())
+	config := LambdaConfig{APIBaseURL: server.URL, sink: sink}
+
+	assert.NoError(getAndSaveGroupRiskScores(config, []GroupSummary{{GroupID: 1}, {GroupID: 2}}))
+	assert.Len(readRiskScoreRows(t, sink, groupRiskScoresPrefix+time.Now().Format("2006-01-02")+".jsonl"), 2)
+}
+
+func Test_getAndSaveUserRiskScores_listsUsers(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+usersURLPath, getTestHandler(exampleUsers))
+	mux.HandleFunc("/"+fmt.Sprintf(userRiskScoreHistoryURLPath, 667542), getTestHandler(exampleRiskScoreHistory))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := LambdaConfig{
+		APIBaseURL: server.URL,
+		manifest:   newRunManifest("run1", time.Now().UTC()),
+		sink:       newFileSink(t.TempDir()),
+	}
+	assert.NoError(getAndSaveUserRiskScores(config, nil))
+	assert.Equal(1, config.manifest.entityPages(entityUsers), "the users page should be counted as users")
+}
@@ -0,0 +1,133 @@
+package archiver
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// fakeS3Object is an object held by fakeS3, with the headers it was uploaded with
+type fakeS3Object struct {
+	body         []byte
+	header       http.Header
+	lastModified time.Time
+}
+
+// fakeS3 is a local stand-in for S3, handling just the requests s3Sink makes with path-style URLs:
+// PutObject, HeadObject, GetObject and ListObjectsV2 for a single bucket
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string]fakeS3Object
+	puts    int
+}
+
+// newTestS3Sink returns an s3Sink writing to a fakeS3 in bucket "archive"
+func newTestS3Sink(t *testing.T, prefix string) (*s3Sink, *fakeS3) {
+	fake := &fakeS3{objects: map[string]fakeS3Object{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	}))
+	return &s3Sink{bucket: "archive", prefix: prefix, sess: sess}, fake
+}
+
+func (f *fakeS3) object(key string) (fakeS3Object, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	o, ok := f.objects[key]
+	return o, ok
+}
+
+func (f *fakeS3) putCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.puts
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/archive")
+	key = strings.TrimPrefix(key, "/")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && key != "":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = fakeS3Object{body: body, header: r.Header.Clone(), lastModified: time.Now().UTC()}
+		f.puts++
+		w.WriteHeader(http.StatusOK)
+	case (r.Method == http.MethodHead || r.Method == http.MethodGet) && key != "":
+		o, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			}
+			return
+		}
+		for name, values := range o.header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") || name == "Content-Type" ||
+				name == "Content-Encoding" {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.body)))
+		w.Header().Set("Last-Modified", o.lastModified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(o.body)
+		}
+	case r.Method == http.MethodGet:
+		f.list(w, r.URL.Query().Get("prefix"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: "archive"}
+
+	for key, o := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents,
+				content{Key: key, Size: len(o.body), LastModified: o.lastModified.Format(time.RFC3339)})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
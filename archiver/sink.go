@@ -0,0 +1,407 @@
+package archiver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// ErrObjectNotFound is returned by a Sink when there is no object with the requested key
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes an object held by a Sink
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+
+	// SHA256 is the hex SHA-256 of the object's content, if the sink knows it. It is only set by Stat.
+	SHA256 string
+}
+
+// sha256MetadataKey is the metadata S3 objects keep their SHA-256 in, as multipart ETags aren't checksums
+const sha256MetadataKey = "sha256"
+
+// PutOptions are settings for an object written to a Sink
+type PutOptions struct {
+	ContentType string
+
+	// ContentEncoding names the compression of the object, e.g. "gzip". Sinks that can't record it ignore it.
+	ContentEncoding string
+
+	// Metadata is stored with the object. Sinks that can't record it ignore it.
+	Metadata map[string]string
+}
+
+// Sink is where archived objects are written. Keys are slash-separated paths such as
+// "users/knowbe4_users_2026-10-16.jsonl".
+type Sink interface {
+	// Put writes everything read from body to the object at key, replacing any existing object.
+	// If reading body fails, no object is written.
+	Put(key string, body io.Reader, opts PutOptions) error
+
+	// Stat returns details of the object at key, or ErrObjectNotFound
+	Stat(key string) (ObjectInfo, error)
+
+	// List returns every object whose key starts with prefix, sorted by key
+	List(prefix string) ([]ObjectInfo, error)
+
+	// Get opens the object at key for reading, or returns ErrObjectNotFound
+	Get(key string) (io.ReadCloser, error)
+}
+
+// S3 server-side encryption modes
+const (
+	sseAES256 = "AES256"
+	sseKMS    = "aws:kms"
+)
+
+// S3Options are settings for every object written to S3. Other sinks ignore them.
+type S3Options struct {
+	// ServerSideEncryption is "AES256", "aws:kms", or empty to use the bucket's default encryption
+	ServerSideEncryption string
+
+	// SSEKMSKeyID is the KMS key for "aws:kms" encryption. If it is empty, S3 uses the AWS managed key.
+	SSEKMSKeyID string
+
+	// Tags are added to every object
+	Tags map[string]string
+}
+
+func (o S3Options) validate() error {
+	switch o.ServerSideEncryption {
+	case "", sseAES256:
+		if o.SSEKMSKeyID != "" {
+			return fmt.Errorf("a KMS key ID needs server-side encryption %s", sseKMS)
+		}
+	case sseKMS:
+	default:
+		return fmt.Errorf("invalid server-side encryption %q, must be %s or %s", o.ServerSideEncryption,
+			sseAES256, sseKMS)
+	}
+
+	// https://docs.aws.amazon.com/AmazonS3/latest/userguide/object-tagging.html
+	if len(o.Tags) > 10 {
+		return fmt.Errorf("%d object tags given, S3 allows at most 10", len(o.Tags))
+	}
+	for k, v := range o.Tags {
+		if k == "" || len(k) > 128 || len(v) > 256 {
+			return fmt.Errorf("invalid object tag %q=%q, keys must be 1 to 128 characters and values at most 256",
+				k, v)
+		}
+	}
+	return nil
+}
+
+// tagging encodes the tags as the query string S3 expects, sorted by key
+func (o S3Options) tagging() string {
+	if len(o.Tags) == 0 {
+		return ""
+	}
+	values := url.Values{}
+	for k, v := range o.Tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// parseObjectTags parses tags given as "key=value,key=value"
+func parseObjectTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid object tag %q, must be key=value", pair)
+		}
+		tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return tags, nil
+}
+
+// newSink makes a Sink for a destination URL: "s3://bucket/optional/prefix" or "file:///some/directory".
+// s3Options apply to S3 destinations only.
+func newSink(destination string, s3Options S3Options) (Sink, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q: %s", destination, err)
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("destination %q has no bucket name", destination)
+		}
+		if err := s3Options.validate(); err != nil {
+			return nil, err
+		}
+		sink := newS3Sink(u.Host, prefix)
+		sink.options = s3Options
+		return sink, nil
+	case "file":
+		dir := u.Path
+		if u.Host != "" {
+			// Allow relative paths like file://archive/data
+			dir = filepath.Join(u.Host, u.Path)
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("destination %q has no directory", destination)
+		}
+		return newFileSink(dir), nil
+	default:
+		return nil, fmt.Errorf("unsupported destination %q, must start with s3:// or file://", destination)
+	}
+}
+
+// s3Sink stores objects in an S3 bucket, optionally under a key prefix
+type s3Sink struct {
+	bucket  string
+	prefix  string
+	options S3Options
+	sess    *session.Session
+}
+
+func newS3Sink(bucket, prefix string) *s3Sink {
+	return &s3Sink{bucket: bucket, prefix: prefix, sess: session.Must(session.NewSession())}
+}
+
+func (s *s3Sink) fullKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+// Put uploads body in parts, without knowing its size in advance
+func (s *s3Sink) Put(key string, body io.Reader, opts PutOptions) error {
+	uploader := s3manager.NewUploader(s.sess, func(u *s3manager.Uploader) {
+		u.Concurrency = 1
+	})
+
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.fullKey(key)),
+		Body:   body,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+	if len(opts.Metadata) > 0 {
+		input.Metadata = aws.StringMap(opts.Metadata)
+	}
+	if s.options.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(s.options.ServerSideEncryption)
+	}
+	if s.options.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.options.SSEKMSKeyID)
+	}
+	if tagging := s.options.tagging(); tagging != "" {
+		input.Tagging = aws.String(tagging)
+	}
+
+	if _, err := uploader.Upload(input); err != nil {
+		return fmt.Errorf("error saving data to s3://%s/%s ... %s", s.bucket, s.fullKey(key), err)
+	}
+	return nil
+}
+
+func (s *s3Sink) Stat(key string) (ObjectInfo, error) {
+	out, err := s3.New(s.sess).HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.fullKey(key)),
+	})
+	if err != nil {
+		return ObjectInfo{}, s.wrapError(key, err)
+	}
+	info := ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		LastModified: aws.TimeValue(out.LastModified),
+	}
+
+	// S3 returns metadata keys in canonical header form, e.g. "Sha256"
+	for k, v := range out.Metadata {
+		if strings.EqualFold(k, sha256MetadataKey) {
+			info.SHA256 = aws.StringValue(v)
+		}
+	}
+	return info, nil
+}
+
+func (s *s3Sink) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := s3.New(s.sess).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.fullKey(prefix)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			key := aws.StringValue(o.Key)
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			objects = append(objects, ObjectInfo{
+				Key:          key,
+				Size:         aws.Int64Value(o.Size),
+				LastModified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing s3://%s/%s ... %s", s.bucket, s.fullKey(prefix), err)
+	}
+	return objects, nil
+}
+
+func (s *s3Sink) Get(key string) (io.ReadCloser, error) {
+	out, err := s3.New(s.sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.fullKey(key)),
+	})
+	if err != nil {
+		return nil, s.wrapError(key, err)
+	}
+	return out.Body, nil
+}
+
+func (s *s3Sink) wrapError(key string, err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		// HeadObject has no body to carry an error code, so it reports a bare "NotFound"
+		if awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound" {
+			return fmt.Errorf("s3://%s/%s: %w", s.bucket, s.fullKey(key), ErrObjectNotFound)
+		}
+	}
+	return fmt.Errorf("error reading s3://%s/%s ... %s", s.bucket, s.fullKey(key), err)
+}
+
+// fileSink stores objects as files under a local directory, using the key as the relative path
+type fileSink struct {
+	dir string
+}
+
+func newFileSink(dir string) *fileSink {
+	return &fileSink{dir: dir}
+}
+
+func (f *fileSink) path(key string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// Put writes to a temporary file first, so a failed write never leaves a partial object behind
+func (f *fileSink) Put(key string, body io.Reader, opts PutOptions) error {
+	p := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("error creating directory for %s ... %s", p, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating %s ... %s", p, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving data to %s ... %s", p, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving data to %s ... %s", p, err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("error saving data to %s ... %s", p, err)
+	}
+	return nil
+}
+
+// Stat reads the whole file to work out its SHA-256, since files have nowhere to keep it
+func (f *fileSink) Stat(key string) (ObjectInfo, error) {
+	file, err := os.Open(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", f.path(key), ErrObjectNotFound)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return ObjectInfo{}, fmt.Errorf("error reading %s ... %s", f.path(key), err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		LastModified: fi.ModTime(),
+		SHA256:       hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func (f *fileSink) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(f.dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(f.dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: fi.Size(), LastModified: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s ... %s", f.dir, err)
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (f *fileSink) Get(key string) (io.ReadCloser, error) {
+	file, err := os.Open(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", f.path(key), ErrObjectNotFound)
+	}
+	return file, err
+}
@@ -0,0 +1,215 @@
+package archiver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newSink(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		want        Sink
+		wantErr     bool
+	}{
+		{name: "file absolute", destination: "file:///tmp/archive", want: &fileSink{dir: "/tmp/archive"}},
+		{name: "file relative", destination: "file://archive/data", want: &fileSink{dir: "archive/data"}},
+		{name: "file no path", destination: "file://", wantErr: true},
+		{name: "s3 no bucket", destination: "s3:///prefix", wantErr: true},
+		{name: "unknown scheme", destination: "ftp://example.com", wantErr: true},
+		{name: "no scheme", destination: "my-bucket", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newSink(tt.destination, S3Options{})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_newSink_s3(t *testing.T) {
+	assert := require.New(t)
+
+	got, err := newSink("s3://my-bucket/some/prefix/", S3Options{})
+	assert.NoError(err)
+
+	s3, ok := got.(*s3Sink)
+	assert.True(ok)
+	assert.Equal("my-bucket", s3.bucket)
+	assert.Equal("some/prefix/users/u.jsonl", s3.fullKey("users/u.jsonl"))
+}
+
+func Test_fileSink(t *testing.T) {
+	assert := require.New(t)
+
+	sink := newFileSink(filepath.Join(t.TempDir(), "archive"))
+
+	_, err := sink.Stat(groupsFilename)
+	assert.True(errors.Is(err, ErrObjectNotFound))
+	_, err = sink.Get(groupsFilename)
+	assert.True(errors.Is(err, ErrObjectNotFound))
+
+	objects, err := sink.List("")
+	assert.NoError(err)
+	assert.Empty(objects)
+
+	assert.NoError(sink.Put(groupsFilename, bytes.NewReader([]byte("{}\n")), PutOptions{}))
+	assert.NoError(sink.Put(usersFilenamePrefix+"2026-10-16.jsonl", bytes.NewReader([]byte("{}\n{}\n")), PutOptions{}))
+
+	info, err := sink.Stat(groupsFilename)
+	assert.NoError(err)
+	assert.Equal(int64(3), info.Size)
+
+	r, err := sink.Get(groupsFilename)
+	assert.NoError(err)
+	b, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal("{}\n", string(b))
+
+	objects, err = sink.List("users/")
+	assert.NoError(err)
+	assert.Len(objects, 1)
+	assert.Equal("users/knowbe4_users_2026-10-16.jsonl", objects[0].Key)
+
+	objects, err = sink.List("")
+	assert.NoError(err)
+	assert.Len(objects, 2)
+}
+
+func Test_fileSink_failedPut(t *testing.T) {
+	assert := require.New(t)
+
+	sink := newFileSink(t.TempDir())
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte("partial"))
+		pw.CloseWithError(errors.New("api failed"))
+	}()
+
+	assert.Error(sink.Put(groupsFilename, pr, PutOptions{}))
+
+	objects, err := sink.List("")
+	assert.NoError(err)
+	assert.Empty(objects, "a failed put should not leave anything behind")
+}
+
+func Test_s3Sink(t *testing.T) {
+	assert := require.New(t)
+
+	sink, fake := newTestS3Sink(t, "knowbe4")
+
+	_, err := sink.Stat(groupsFilename)
+	assert.True(errors.Is(err, ErrObjectNotFound))
+	_, err = sink.Get(groupsFilename)
+	assert.True(errors.Is(err, ErrObjectNotFound))
+
+	opts := PutOptions{ContentType: "application/x-ndjson", Metadata: map[string]string{sha256MetadataKey: "abc"}}
+	assert.NoError(sink.Put(groupsFilename, bytes.NewReader([]byte("{}\n")), opts))
+	assert.NoError(sink.Put(usersFilenamePrefix+"2026-10-16.jsonl", bytes.NewReader([]byte("{}\n{}\n")), PutOptions{}))
+
+	o, ok := fake.object("knowbe4/" + groupsFilename)
+	assert.True(ok)
+	assert.Equal("application/x-ndjson", o.header.Get("Content-Type"))
+
+	info, err := sink.Stat(groupsFilename)
+	assert.NoError(err)
+	assert.Equal(int64(3), info.Size)
+	assert.Equal("abc", info.SHA256)
+
+	r, err := sink.Get(groupsFilename)
+	assert.NoError(err)
+	b, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal("{}\n", string(b))
+
+	objects, err := sink.List("users/")
+	assert.NoError(err)
+	assert.Len(objects, 1)
+	assert.Equal("users/knowbe4_users_2026-10-16.jsonl", objects[0].Key)
+	assert.Equal(int64(6), objects[0].Size)
+}
+
+func Test_s3Sink_encryptionAndTags(t *testing.T) {
+	assert := require.New(t)
+
+	sink, fake := newTestS3Sink(t, "")
+	sink.options = S3Options{
+		ServerSideEncryption: sseKMS,
+		SSEKMSKeyID:          "arn:aws:kms:us-east-1:111122223333:key/abc",
+		Tags:                 map[string]string{"owner": "security team", "classification": "pii"},
+	}
+
+	assert.NoError(sink.Put(groupsFilename, bytes.NewReader([]byte("{}\n")), PutOptions{}))
+
+	o, ok := fake.object(groupsFilename)
+	assert.True(ok)
+	assert.Equal(sseKMS, o.header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal("arn:aws:kms:us-east-1:111122223333:key/abc",
+		o.header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	assert.Equal("classification=pii&owner=security+team", o.header.Get("X-Amz-Tagging"))
+
+	sink.options = S3Options{}
+	assert.NoError(sink.Put(groupsFilename, bytes.NewReader([]byte("{}\n")), PutOptions{}))
+	o, _ = fake.object(groupsFilename)
+	assert.Empty(o.header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Empty(o.header.Get("X-Amz-Tagging"))
+}
+
+func Test_S3Options_validate(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := 0; i < 11; i++ {
+		tooMany[string(rune('a'+i))] = "x"
+	}
+
+	tests := []struct {
+		name    string
+		options S3Options
+		wantErr bool
+	}{
+		{name: "none", options: S3Options{}},
+		{name: "AES256", options: S3Options{ServerSideEncryption: sseAES256}},
+		{name: "KMS with a key", options: S3Options{ServerSideEncryption: sseKMS, SSEKMSKeyID: "key"}},
+		{name: "KMS managed key", options: S3Options{ServerSideEncryption: sseKMS}},
+		{name: "key without KMS", options: S3Options{SSEKMSKeyID: "key"}, wantErr: true},
+		{name: "unknown mode", options: S3Options{ServerSideEncryption: "aws:kms:dsse"}, wantErr: true},
+		{name: "too many tags", options: S3Options{Tags: tooMany}, wantErr: true},
+		{name: "empty tag key", options: S3Options{Tags: map[string]string{"": "x"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.validate()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	_, err := newSink("s3://bucket", S3Options{ServerSideEncryption: "des"})
+	require.Error(t, err)
+	_, err = newSink("file:///tmp/archive", S3Options{ServerSideEncryption: "des"})
+	require.NoError(t, err, "S3 options don't apply to files")
+}
+
+func Test_parseObjectTags(t *testing.T) {
+	assert := require.New(t)
+
+	tags, err := parseObjectTags("classification=pii, owner = security,")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"classification": "pii", "owner": "security"}, tags)
+
+	_, err = parseObjectTags("classification")
+	assert.Error(err)
+}
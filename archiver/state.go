@@ -0,0 +1,322 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	stateFilename = "state/knowbe4_archiver_state.json"
+
+	// Recipients of a security test in this status will not change any more
+	securityTestStatusClosed = "Closed"
+)
+
+// ArchiveState is the checkpoint kept between runs, recording which security tests have already had
+// their recipients archived
+type ArchiveState struct {
+	SecurityTests map[int]ArchivedSecurityTest `json:"security_tests"`
+
+	// RiskScoreDates holds the latest risk score history date saved for each user and group, keyed
+	// like "users/123"
+	RiskScoreDates map[string]string `json:"risk_score_dates"`
+
+	// APIRequests counts the API requests made on the latest UTC day there were any, keyed by the day
+	APIRequests map[string]int `json:"api_requests"`
+
+	mutex sync.Mutex
+}
+
+// ArchivedSecurityTest records the status a security test had when its recipients were last archived,
+// and the key they were saved at
+type ArchivedSecurityTest struct {
+	Status     string    `json:"status"`
+	Key        string    `json:"key,omitempty"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+func newArchiveState() *ArchiveState {
+	return &ArchiveState{
+		SecurityTests:  map[int]ArchivedSecurityTest{},
+		RiskScoreDates: map[string]string{},
+		APIRequests:    map[string]int{},
+	}
+}
+
+// markArchived records that the recipients of a security test have been saved at key
+func (s *ArchiveState) markArchived(pstID int, status, key string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.SecurityTests[pstID] = ArchivedSecurityTest{Status: status, Key: key, ArchivedAt: at}
+}
+
+// needsRecipients reports whether the recipients of a security test still need to be archived, because
+// the test wasn't Closed when they were or the object they were saved to isn't among saved, the keys
+// returned by savedRecipients. A nil saved skips the check for the object.
+func (s *ArchiveState) needsRecipients(config LambdaConfig, test KnowBe4SecurityTest, saved map[string]bool) bool {
+	s.mutex.Lock()
+	archived, ok := s.SecurityTests[test.PstID]
+	s.mutex.Unlock()
+	if !ok || archived.Status != securityTestStatusClosed {
+		return true
+	}
+	if saved == nil {
+		return false
+	}
+
+	if key := archivedRecipientsKey(config, test.PstID, archived); !saved[key] {
+		log.Printf("saving recipients of security test %d again, %s can't be found", test.PstID, key)
+		return true
+	}
+	return false
+}
+
+// savedRecipients returns the keys of the saved recipient objects of the Closed security tests in state,
+// found with a single List of the prefix they share. It returns nil if there is no sink. If the List
+// fails, the map is empty, so every Closed test is saved again.
+func (s *ArchiveState) savedRecipients(config LambdaConfig) map[string]bool {
+	if config.sink == nil {
+		return nil
+	}
+
+	var keys []string
+	s.mutex.Lock()
+	for id, archived := range s.SecurityTests {
+		if archived.Status == securityTestStatusClosed {
+			keys = append(keys, archivedRecipientsKey(config, id, archived))
+		}
+	}
+	s.mutex.Unlock()
+
+	saved := map[string]bool{}
+	if len(keys) == 0 {
+		return saved
+	}
+	prefix := commonPrefix(keys)
+	objects, err := config.sink.List(prefix)
+	if err != nil {
+		log.Printf("error listing saved recipients under %q, saving them all again ... %s", prefix, err)
+		return saved
+	}
+	for _, o := range objects {
+		saved[o.Key] = true
+	}
+	return saved
+}
+
+// archivedRecipientsKey returns the key the recipients of a security test were saved at. State saved
+// before keys were recorded is checked against the default key.
+func archivedRecipientsKey(config LambdaConfig, pstID int, archived ArchivedSecurityTest) string {
+	if archived.Key != "" {
+		return archived.Key
+	}
+	return config.recordKey(entityRecipients, config.recipientsFileName(KnowBe4SecurityTest{PstID: pstID}))
+}
+
+// commonPrefix returns the longest prefix shared by all of keys
+func commonPrefix(keys []string) string {
+	prefix := keys[0]
+	for _, k := range keys[1:] {
+		n := 0
+		for n < len(prefix) && n < len(k) && prefix[n] == k[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return prefix
+}
+
+// lastRiskScoreDate returns the latest risk score date saved for key, or "" if there is none
+func (s *ArchiveState) lastRiskScoreDate(key string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.RiskScoreDates[key]
+}
+
+// markRiskScoreDate records that risk scores up to date have been saved for key
+func (s *ArchiveState) markRiskScoreDate(key, date string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if date > s.RiskScoreDates[key] {
+		s.RiskScoreDates[key] = date
+	}
+}
+
+// apiRequests returns the number of API requests recorded for a UTC day, like "2026-10-16"
+func (s *ArchiveState) apiRequests(day string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.APIRequests[day]
+}
+
+// addAPIRequests adds count to the API requests recorded for a UTC day, forgetting earlier days, and
+// returns the new total
+func (s *ArchiveState) addAPIRequests(day string, count int) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	total := s.APIRequests[day] + count
+	s.APIRequests = map[string]int{day: total}
+	return total
+}
+
+// merge adds the progress recorded in saved, a copy of the state saved by another run since s was loaded.
+// The latest archive of each security test and the latest risk score dates are kept, and the API request
+// counts are taken from saved, since each run only adds the requests it made when saving.
+func (s *ArchiveState) merge(saved *ArchiveState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, test := range saved.SecurityTests {
+		if current, ok := s.SecurityTests[id]; !ok || test.ArchivedAt.After(current.ArchivedAt) {
+			s.SecurityTests[id] = test
+		}
+	}
+	for key, date := range saved.RiskScoreDates {
+		if date > s.RiskScoreDates[key] {
+			s.RiskScoreDates[key] = date
+		}
+	}
+	counts := map[string]int{}
+	for day, count := range saved.APIRequests {
+		counts[day] = count
+	}
+	s.APIRequests = counts
+}
+
+// StateStore loads and saves the ArchiveState between runs
+type StateStore interface {
+	// Load returns the saved state, or an empty state if none has been saved yet
+	Load() (*ArchiveState, error)
+	Save(state *ArchiveState) error
+}
+
+// updateState loads the saved state, passes it to fn and saves it again, even if fn fails. Runs can
+// overlap, so the state is loaded again just before saving and what other runs saved in the meantime is
+// merged in. Only runs saving at the same moment can still lose each other's changes.
+func updateState(config LambdaConfig, fn func(state *ArchiveState) error) error {
+	if config.stateStore == nil {
+		return fn(newArchiveState())
+	}
+
+	state, err := config.stateStore.Load()
+	if err != nil {
+		return errors.New("error loading archiver state ... " + err.Error())
+	}
+
+	fnErr := fn(state)
+
+	if err := saveState(config, state); err != nil {
+		if fnErr != nil {
+			return fmt.Errorf("%s; %s", fnErr, err)
+		}
+		return err
+	}
+
+	return fnErr
+}
+
+// saveState merges the latest saved state into state, adds the run's API requests and saves it
+func saveState(config LambdaConfig, state *ArchiveState) error {
+	saved, err := config.stateStore.Load()
+	if err != nil {
+		return errors.New("error loading archiver state ... " + err.Error())
+	}
+	state.merge(saved)
+	usage := config.limiter.saveUsage(state)
+
+	if err := config.stateStore.Save(state); err != nil {
+		return errors.New("error saving archiver state ... " + err.Error())
+	}
+	config.limiter.usageSaved(usage)
+	return nil
+}
+
+// sinkStateStore keeps the ArchiveState as a JSON object alongside the archived data
+type sinkStateStore struct {
+	sink Sink
+	key  string
+}
+
+func newSinkStateStore(sink Sink) *sinkStateStore {
+	return &sinkStateStore{sink: sink, key: stateFilename}
+}
+
+func (s *sinkStateStore) Load() (*ArchiveState, error) {
+	body, err := s.sink.Get(s.key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return newArchiveState(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state from %s ... %s", s.key, err)
+	}
+	defer body.Close()
+
+	return decodeArchiveState(body)
+}
+
+func (s *sinkStateStore) Save(state *ArchiveState) error {
+	b, err := encodeArchiveState(state)
+	if err != nil {
+		return err
+	}
+
+	if err := s.sink.Put(s.key, bytes.NewReader(b), PutOptions{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("error saving state to %s ... %s", s.key, err)
+	}
+	return nil
+}
+
+func decodeArchiveState(r io.Reader) (*ArchiveState, error) {
+	state := newArchiveState()
+	if err := json.NewDecoder(r).Decode(state); err != nil {
+		return nil, fmt.Errorf("error decoding archiver state: %s", err)
+	}
+	if state.SecurityTests == nil {
+		state.SecurityTests = map[int]ArchivedSecurityTest{}
+	}
+	if state.RiskScoreDates == nil {
+		state.RiskScoreDates = map[string]string{}
+	}
+	if state.APIRequests == nil {
+		state.APIRequests = map[string]int{}
+	}
+	return state, nil
+}
+
+func encodeArchiveState(state *ArchiveState) ([]byte, error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding archiver state: %s", err)
+	}
+	return b, nil
+}
+
+// loadAPIUsage counts the API requests already recorded for today against the daily budget
+func loadAPIUsage(config LambdaConfig) error {
+	if config.stateStore == nil || config.APIDailyRequestLimit == 0 {
+		return nil
+	}
+	state, err := config.stateStore.Load()
+	if err != nil {
+		return errors.New("error loading archiver state ... " + err.Error())
+	}
+	config.limiter.loadUsage(state)
+	return nil
+}
+
+// saveAPIUsage records the API requests made today in the saved state
+func saveAPIUsage(config LambdaConfig) error {
+	if config.APIDailyRequestLimit == 0 {
+		return nil
+	}
+	return updateState(config, func(*ArchiveState) error { return nil })
+}
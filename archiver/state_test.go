@@ -0,0 +1,132 @@
+package archiver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStateStore struct {
+	state *ArchiveState
+	saves int
+}
+
+func (m *memoryStateStore) Load() (*ArchiveState, error) {
+	if m.state == nil {
+		return newArchiveState(), nil
+	}
+	return m.state, nil
+}
+
+func (m *memoryStateStore) Save(state *ArchiveState) error {
+	m.state = state
+	m.saves++
+	return nil
+}
+
+func Test_ArchiveState_needsRecipients(t *testing.T) {
+	state := newArchiveState()
+	state.markArchived(1, "Closed", "", time.Now())
+	state.markArchived(2, "Open", "", time.Now())
+
+	tests := []struct {
+		name string
+		test KnowBe4SecurityTest
+		want bool
+	}{
+		{name: "new", test: KnowBe4SecurityTest{PstID: 3, Status: "Open"}, want: true},
+		{name: "archived after closing", test: KnowBe4SecurityTest{PstID: 1, Status: "Closed"}, want: false},
+		{name: "archived while open", test: KnowBe4SecurityTest{PstID: 2, Status: "Closed"}, want: true},
+		{name: "still open", test: KnowBe4SecurityTest{PstID: 2, Status: "Open"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, state.needsRecipients(LambdaConfig{}, tt.test, nil))
+		})
+	}
+}
+
+// listCountingSink counts the requests made to check for saved objects
+type listCountingSink struct {
+	Sink
+	lists, stats int
+}
+
+func (s *listCountingSink) List(prefix string) ([]ObjectInfo, error) {
+	s.lists++
+	return s.Sink.List(prefix)
+}
+
+func (s *listCountingSink) Stat(key string) (ObjectInfo, error) {
+	s.stats++
+	return s.Sink.Stat(key)
+}
+
+func Test_ArchiveState_needsRecipients_missingObject(t *testing.T) {
+	assert := require.New(t)
+
+	sink := &listCountingSink{Sink: newFileSink(t.TempDir())}
+	config := LambdaConfig{sink: sink}
+	saved := config.recordKey(entityRecipients, config.recipientsFileName(KnowBe4SecurityTest{PstID: 1}))
+	assert.NoError(sink.Put(saved, bytes.NewReader([]byte("{}")), PutOptions{}))
+
+	state := newArchiveState()
+	state.markArchived(1, "Closed", saved, time.Now())
+	state.markArchived(2, "Closed", "recipients/expired.jsonl", time.Now())
+	state.markArchived(3, "Closed", "", time.Now())
+	state.markArchived(4, "Open", "recipients/open.jsonl", time.Now())
+
+	keys := state.savedRecipients(config)
+	assert.Equal(1, sink.lists, "saved recipients should be found with one List")
+	assert.Equal(0, sink.stats)
+
+	assert.False(state.needsRecipients(config, KnowBe4SecurityTest{PstID: 1, Status: "Closed"}, keys))
+	assert.True(state.needsRecipients(config, KnowBe4SecurityTest{PstID: 2, Status: "Closed"}, keys))
+	assert.True(state.needsRecipients(config, KnowBe4SecurityTest{PstID: 3, Status: "Closed"}, keys),
+		"state saved before keys were recorded should be checked against the default key")
+}
+
+func Test_commonPrefix(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("recipients/knowbe4_recipients_", commonPrefix([]string{
+		"recipients/knowbe4_recipients_12.jsonl", "recipients/knowbe4_recipients_3.jsonl"}))
+	assert.Equal("entity=recipients/dt=2026-", commonPrefix([]string{
+		"entity=recipients/dt=2026-10-16/part-0012.jsonl", "entity=recipients/dt=2026-01-02/part-0003.jsonl"}))
+	assert.Equal("a.jsonl", commonPrefix([]string{"a.jsonl"}))
+}
+
+func Test_ArchiveState_encoding(t *testing.T) {
+	assert := require.New(t)
+
+	archivedAt := time.Date(2026, 10, 16, 6, 10, 0, 0, time.UTC)
+	state := newArchiveState()
+	state.markArchived(16142, "Closed", "recipients/knowbe4_recipients_16142.jsonl", archivedAt)
+
+	b, err := encodeArchiveState(state)
+	assert.NoError(err)
+
+	got, err := decodeArchiveState(bytes.NewReader(b))
+	assert.NoError(err)
+	assert.Equal(state.SecurityTests, got.SecurityTests)
+
+	got, err = decodeArchiveState(bytes.NewReader([]byte("{}")))
+	assert.NoError(err)
+	assert.NotNil(got.SecurityTests)
+}
+
+func Test_saveRecipients_skipsClosedTests(t *testing.T) {
+	assert := require.New(t)
+
+	store := &memoryStateStore{state: newArchiveState()}
+	store.state.markArchived(1, "Closed", "", time.Now())
+	store.state.markArchived(2, "Closed", "", time.Now())
+
+	config := LambdaConfig{stateStore: store}
+	secTests := []KnowBe4SecurityTest{{PstID: 1, Status: "Closed"}, {PstID: 2, Status: "Closed"}}
+
+	assert.NoError(saveRecipients(config, secTests))
+	assert.Equal(1, store.saves)
+}
@@ -1,7 +1,24 @@
-package main
+package archiver
 
 import "time"
 
+type KnowBe4Account struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Domains []string `json:"domains"`
+	Admins  []struct {
+		ID        int    `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+	} `json:"admins"`
+	SubscriptionLevel   string  `json:"subscription_level"`
+	SubscriptionEndDate string  `json:"subscription_end_date"`
+	NumberOfSeats       int     `json:"number_of_seats"`
+	CurrentRiskScore    float64 `json:"current_risk_score"`
+	SnapshotDate        string  `json:"snapshot_date"`
+}
+
 type KnowBe4Recipient struct {
 	RecipientID int `json:"recipient_id"`
 	PstID       int `json:"pst_id"`
@@ -111,12 +128,27 @@ type KnowBe4Group struct {
 	Status           string             `json:"status"`
 }
 
+type KnowBe4GroupMember struct {
+	GroupID      int    `json:"group_id"`
+	GroupName    string `json:"group_name"`
+	UserID       int    `json:"user_id"`
+	Email        string `json:"email"`
+	SnapshotDate string `json:"snapshot_date"`
+}
+
 type RiskScoreHistory struct {
 	GroupID   int     `json:"group_id,omitempty"`
 	RiskScore float64 `json:"risk_score"`
 	Date      string  `json:"date"`
 }
 
+type RiskScoreRow struct {
+	EntityType string  `json:"entity_type"`
+	EntityID   int     `json:"entity_id"`
+	Date       string  `json:"date"`
+	RiskScore  float64 `json:"risk_score"`
+}
+
 type KnowBe4User struct {
 	Id                   int                `json:"id"`
 	EmployeeNumber       string             `json:"employee_number"`
@@ -155,3 +187,79 @@ type KnowBe4User struct {
 	CustomDate2          *time.Time         `json:"custom_date_2"`
 	SnapshotDate         string             `json:"snapshot_date"`
 }
+
+type KnowBe4TrainingCampaign struct {
+	CampaignID               int               `json:"campaign_id"`
+	Name                     string            `json:"name"`
+	Groups                   []GroupSummary    `json:"groups"`
+	Status                   string            `json:"status"`
+	Content                  []TrainingContent `json:"content"`
+	DurationType             string            `json:"duration_type"`
+	StartDate                *time.Time        `json:"start_date"`
+	EndDate                  *time.Time        `json:"end_date"`
+	RelativeDuration         string            `json:"relative_duration"`
+	AutoEnroll               bool              `json:"auto_enroll"`
+	AllowMultipleEnrollments bool              `json:"allow_multiple_enrollments"`
+	CompletionPercentage     float64           `json:"completion_percentage"`
+}
+
+type TrainingContent struct {
+	StorePurchaseID int        `json:"store_purchase_id"`
+	PolicyID        int        `json:"policy_id"`
+	ContentType     string     `json:"content_type"`
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	Type            string     `json:"type"`
+	Duration        int        `json:"duration"`
+	Retired         bool       `json:"retired"`
+	RetirementDate  *time.Time `json:"retirement_date"`
+	PublishDate     *time.Time `json:"publish_date"`
+	Publisher       string     `json:"publisher"`
+	PurchaseDate    *time.Time `json:"purchase_date"`
+	PolicyURL       string     `json:"policy_url"`
+}
+
+type KnowBe4TrainingEnrollment struct {
+	EnrollmentID int    `json:"enrollment_id"`
+	ContentType  string `json:"content_type"`
+	ModuleName   string `json:"module_name"`
+	User         struct {
+		ID        int    `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+	} `json:"user"`
+	CampaignName       string     `json:"campaign_name"`
+	EnrollmentDate     *time.Time `json:"enrollment_date"`
+	StartDate          *time.Time `json:"start_date"`
+	CompletionDate     *time.Time `json:"completion_date"`
+	Status             string     `json:"status"`
+	TimeSpent          int        `json:"time_spent"`
+	PolicyAcknowledged bool       `json:"policy_acknowledged"`
+}
+
+type KnowBe4StorePurchase struct {
+	StorePurchaseID int        `json:"store_purchase_id"`
+	ContentType     string     `json:"content_type"`
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	Type            string     `json:"type"`
+	Duration        int        `json:"duration"`
+	Retired         bool       `json:"retired"`
+	RetirementDate  *time.Time `json:"retirement_date"`
+	PublishDate     *time.Time `json:"publish_date"`
+	Publisher       string     `json:"publisher"`
+	PurchaseDate    *time.Time `json:"purchase_date"`
+	PolicyURL       string     `json:"policy_url"`
+	SnapshotDate    string     `json:"snapshot_date"`
+}
+
+type KnowBe4Policy struct {
+	PolicyID        int    `json:"policy_id"`
+	ContentType     string `json:"content_type"`
+	Name            string `json:"name"`
+	MinimumTime     int    `json:"minimum_time"`
+	DefaultLanguage string `json:"default_language"`
+	Status          int    `json:"status"`
+	SnapshotDate    string `json:"snapshot_date"`
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/silinternational/knowbe4-data-archiver/archiver"
+)
+
+func main() {
+	os.Exit(archiver.RunCLI(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/silinternational/knowbe4-data-archiver/archiver"
+)
+
+func main() {
+	lambda.Start(archiver.Handler)
+}
@@ -7,4 +7,7 @@ set -e
 set -x
 
 # Build all the things
-go build -ldflags="-s -w" -o bin/archiver  archiver/main.go archiver/types.go
+LDFLAGS="-s -w -X github.com/silinternational/knowbe4-data-archiver/archiver.version=${CI_COMMIT_ID:-dev}"
+go build -ldflags="$LDFLAGS" -o bin/archiver ./cmd/lambda
+# The command line tool goes outside bin/, which serverless packages into the Lambda zip
+go build -ldflags="$LDFLAGS" -o dist/knowbe4-archiver ./cmd/knowbe4-archiver